@@ -0,0 +1,34 @@
+package matchbox
+
+import (
+	"fmt"
+	"strings"
+
+	butane "github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	"github.com/coreos/vcontext/report"
+)
+
+// transpileButane transpiles a Butane config to Ignition. Local files are
+// embedded from filesDir, if set. In strict mode, warnings are errors.
+func transpileButane(content, filesDir string, strict bool) (string, error) {
+	ignition, rpt, err := butane.TranslateBytes([]byte(content), common.TranslateBytesOptions{
+		TranslateOptions: common.TranslateOptions{
+			FilesDir: filesDir,
+		},
+	})
+
+	var problems []string
+	for _, entry := range rpt.Entries {
+		if entry.Kind == report.Error || (strict && entry.Kind == report.Warn) {
+			problems = append(problems, fmt.Sprintf("%s %s", entry.Kind, ignitionEntryDetail(entry)))
+		}
+	}
+	if err == nil && len(problems) == 0 {
+		return string(ignition), nil
+	}
+	if len(problems) == 0 {
+		problems = append(problems, err.Error())
+	}
+	return "", fmt.Errorf("butane_config: failed to transpile Butane to Ignition:\n%s", strings.Join(problems, "\n"))
+}
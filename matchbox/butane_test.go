@@ -0,0 +1,50 @@
+package matchbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranspileButane(t *testing.T) {
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "motd"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	local := `
+variant: fcos
+version: 1.5.0
+storage:
+  files:
+    - path: /etc/motd
+      contents:
+        local: motd
+`
+	cases := []struct {
+		name     string
+		butane   string
+		filesDir string
+		strict   bool
+		expected string
+	}{
+		{"inline", "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: /etc/hostname\n      contents:\n        inline: node1\n", "", false, `"source":"data:,node1"`},
+		{"warning", "variant: fcos\nversion: 1.5.0\nfoo: bar\n", "", false, `{"ignition":{"version":"3.4.0"}}`},
+		{"strict warning", "variant: fcos\nversion: 1.5.0\nfoo: bar\n", "", true, "warning at $.foo, line 3 col 1: Unused key foo"},
+		{"invalid", "variant: fcos\nversion: 1.5.0\nstorage:\n  files:\n    - path: etc/hostname\n", "", false, "error at $.storage.files.0.path, line 5 col 13: path not absolute"},
+		{"unknown version", "variant: fcos\nversion: 9.0.0\n", "", false, "No translator exists for variant fcos with version 9.0.0"},
+		{"local file", local, filesDir, false, `"source":"data:,hello%0A"`},
+		{"local file without files dir", local, "", false, "error at $.storage.files.0.contents.local"},
+	}
+	for _, c := range cases {
+		ignition, err := transpileButane(c.butane, c.filesDir, c.strict)
+		got := ignition
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, c.expected) {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package matchbox
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/poseidon/matchbox/matchbox/server/serverpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Problems connecting to a matchbox endpoint.
+const (
+	problemEndpoint   = "invalid endpoint"
+	problemDNS        = "DNS lookup failed"
+	problemRefused    = "TCP connection refused"
+	problemProxy      = "proxy connection failed"
+	problemTCP        = "TCP connection failed"
+	problemServerCert = "server certificate untrusted"
+	problemRevoked    = "server certificate revoked"
+	problemClientCert = "client certificate rejected"
+	problemTLS        = "TLS handshake failed"
+	problemDeadline   = "deadline exceeded"
+	problemRPC        = "authenticated RPC failed"
+)
+
+// connectionError is a failure to connect to a matchbox endpoint, classified
+// by the problem which occurred.
+type connectionError struct {
+	Endpoint string
+	Problem  string
+	// Cert describes the certificate involved, if any
+	Cert string
+	Err  error
+}
+
+func (e *connectionError) Error() string {
+	if e.Cert != "" {
+		return fmt.Sprintf("%s: %s (%s): %v", e.Endpoint, e.Problem, e.Cert, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Endpoint, e.Problem, e.Err)
+}
+
+func (e *connectionError) Unwrap() error {
+	return e.Err
+}
+
+// checkConnection connects to the endpoint and completes a TLS handshake, so
+// DNS, TCP, proxy, and server certificate problems can be told apart before
+// gRPC hides them behind a generic transport error.
+func checkConnection(ctx context.Context, endpoint string, dialer func(context.Context, string) (net.Conn, error), tlscfg *tls.Config) error {
+	conn, err := dialer(ctx, endpoint)
+	if err != nil {
+		return classifyDialError(endpoint, err)
+	}
+	defer conn.Close()
+
+	cfg := tlscfg.Clone()
+	cfg.NextProtos = []string{"h2"}
+	if err := tls.Client(conn, cfg).HandshakeContext(ctx); err != nil {
+		return classifyHandshakeError(endpoint, tlscfg, err)
+	}
+	return nil
+}
+
+// probe makes a cheap authenticated RPC to check the server accepts the
+// client certificate. Any response from the server, even an error about the
+// (nonexistent) Profile, means the client was authenticated.
+func probe(ctx context.Context, client *Client, tlscfg *tls.Config) error {
+	_, err := client.Profiles.ProfileGet(ctx, &serverpb.ProfileGetRequest{})
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.DeadlineExceeded, codes.Canceled:
+		return &connectionError{Endpoint: client.Endpoint, Problem: problemDeadline, Err: err}
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return &connectionError{Endpoint: client.Endpoint, Problem: problemClientCert, Cert: describeClientCert(tlscfg), Err: err}
+	case codes.Unavailable:
+		if isCertificateAlert(err) {
+			return &connectionError{Endpoint: client.Endpoint, Problem: problemClientCert, Cert: describeClientCert(tlscfg), Err: err}
+		}
+		return &connectionError{Endpoint: client.Endpoint, Problem: problemRPC, Err: err}
+	}
+	return nil
+}
+
+func classifyDialError(endpoint string, err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var proxyErr *proxyError
+	problem := problemTCP
+	switch {
+	case errors.As(err, &proxyErr):
+		problem = problemProxy
+	case errors.As(err, &dnsErr):
+		problem = problemDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		problem = problemRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		problem = problemDeadline
+	}
+	return &connectionError{Endpoint: endpoint, Problem: problem, Err: err}
+}
+
+func classifyHandshakeError(endpoint string, tlscfg *tls.Config, err error) error {
+	var verifyErr *tls.CertificateVerificationError
+	var pinErr *pinError
+	var revokedErr *revokedError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &revokedErr):
+		return &connectionError{Endpoint: endpoint, Problem: problemRevoked, Cert: describeCert("server", revokedErr.Cert), Err: err}
+	case errors.As(err, &pinErr):
+		return &connectionError{Endpoint: endpoint, Problem: problemServerCert, Cert: describeCert("server", pinErr.Cert), Err: err}
+	case errors.As(err, &verifyErr):
+		var cert string
+		if len(verifyErr.UnverifiedCertificates) > 0 {
+			cert = describeCert("server", verifyErr.UnverifiedCertificates[0])
+		}
+		return &connectionError{Endpoint: endpoint, Problem: problemServerCert, Cert: cert, Err: err}
+	case isCertificateAlert(err):
+		// TLS 1.2 servers reject client certificates during the handshake
+		return &connectionError{Endpoint: endpoint, Problem: problemClientCert, Cert: describeClientCert(tlscfg), Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &connectionError{Endpoint: endpoint, Problem: problemDeadline, Err: err}
+	}
+	return &connectionError{Endpoint: endpoint, Problem: problemTLS, Err: err}
+}
+
+// isCertificateAlert returns true if the error is a TLS alert sent by the
+// server about the client certificate, such as bad_certificate or
+// certificate_required. The crypto/tls alert type isn't exported.
+func isCertificateAlert(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, alert, ok := strings.Cut(err.Error(), "remote error: tls: ")
+	return ok && strings.Contains(alert, "certificate")
+}
+
+// describeClientCert describes the client certificate in the TLS config.
+func describeClientCert(tlscfg *tls.Config) string {
+	if len(tlscfg.Certificates) == 0 {
+		return ""
+	}
+	cert := tlscfg.Certificates[0]
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return ""
+		}
+		cert.Leaf = leaf
+	}
+	return describeCert("client", cert.Leaf)
+}
+
+// describeCert describes a certificate's subject and expiry.
+func describeCert(kind string, cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	expiry := cert.NotAfter.UTC().Format(time.RFC3339)
+	if time.Now().After(cert.NotAfter) {
+		expiry += ", expired"
+	}
+	return fmt.Sprintf("%s certificate %q expires %s", kind, cert.Subject.String(), expiry)
+}
+
+// certExpiryDiagnostics returns an error for each PEM certificate which has
+// expired and a warning for each which expires within the window.
+func certExpiryDiagnostics(kind string, pemCerts []byte, window time.Duration) diag.Diagnostics {
+	var diags diag.Diagnostics
+	now := time.Now()
+	for block, rest := pem.Decode(pemCerts); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			// reported when loading TLS credentials
+			continue
+		}
+		switch {
+		case now.After(cert.NotAfter):
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Matchbox %s certificate expired", kind),
+				Detail:   fmt.Sprintf("%s certificate %q expired %s", kind, cert.Subject.String(), cert.NotAfter.UTC().Format(time.RFC3339)),
+			})
+		case now.Add(window).After(cert.NotAfter):
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Matchbox %s certificate expires soon", kind),
+				Detail: fmt.Sprintf("%s certificate %q expires %s (in %d days)", kind, cert.Subject.String(),
+					cert.NotAfter.UTC().Format(time.RFC3339), int(cert.NotAfter.Sub(now).Hours()/24)),
+			})
+		}
+	}
+	return diags
+}
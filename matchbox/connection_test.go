@@ -0,0 +1,461 @@
+package matchbox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// TestDial checks failures to connect to an endpoint are classified.
+func TestDial(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	// endpoint which refuses connections
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve address: %v", err)
+	}
+	refused := lis.Addr().String()
+	lis.Close()
+
+	// endpoint which accepts connections, but never responds
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	selfSignedCert, selfSignedKey := selfSignedClient(t, "self-signed", time.Now().Add(time.Hour))
+	endpoint := srv.Listener.Addr().String()
+	cases := []struct {
+		name       string
+		endpoint   string
+		ca         []byte
+		clientCert []byte
+		clientKey  []byte
+		problem    string
+		cert       string
+	}{
+		{"ok", endpoint, fakeCACert, fakeClientCert, fakeClientKey, "", ""},
+		{"dns", "matchbox.invalid:8081", fakeCACert, fakeClientCert, fakeClientKey, problemDNS, ""},
+		{"refused", refused, fakeCACert, fakeClientCert, fakeClientKey, problemRefused, ""},
+		{"deadline", silent.Addr().String(), fakeCACert, fakeClientCert, fakeClientKey, problemDeadline, ""},
+		{"untrusted", endpoint, fakeClientCert, fakeClientCert, fakeClientKey, problemServerCert, `server certificate "CN=fake-server"`},
+		{"rejected", endpoint, fakeCACert, selfSignedCert, selfSignedKey, problemClientCert, `client certificate "CN=self-signed"`},
+	}
+	for _, c := range cases {
+		tlscfg, err := tlsConfig(&Config{CA: c.ca, ClientCert: c.clientCert, ClientKey: c.clientKey})
+		if err != nil {
+			t.Fatalf("%s: tlsConfig: %v", c.name, err)
+		}
+		client, err := dial(c.endpoint, tlscfg, &Config{DialTimeout: time.Second})
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+			} else {
+				client.Close()
+			}
+			continue
+		}
+
+		var connErr *connectionError
+		if !errors.As(err, &connErr) {
+			t.Errorf("%s: expected connectionError, got %v", c.name, err)
+			continue
+		}
+		if connErr.Problem != c.problem {
+			t.Errorf("%s: expected problem %q, got %q (%v)", c.name, c.problem, connErr.Problem, err)
+		}
+		if !strings.Contains(connErr.Cert, c.cert) {
+			t.Errorf("%s: expected cert %q, got %q", c.name, c.cert, connErr.Cert)
+		}
+		if !strings.HasPrefix(err.Error(), c.endpoint) {
+			t.Errorf("%s: expected error to name endpoint %s, got %v", c.name, c.endpoint, err)
+		}
+	}
+}
+
+// TestDial_serverIdentity checks the server certificate is verified against
+// tls_server_name and server_pins.
+func TestDial_serverIdentity(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	serverPin := spkiPin(mustParseCert(t, mustReadFile("testdata/server.crt")))
+	caPin := spkiPin(mustParseCert(t, fakeCACert))
+	otherPin := spkiPin(mustParseCert(t, fakeClientCert))
+
+	cases := []struct {
+		name       string
+		ca         []byte
+		serverName string
+		pins       []string
+		problem    string
+	}{
+		{"server name", fakeCACert, "matchbox.example.com", nil, ""},
+		{"wrong server name", fakeCACert, "wrong.example.com", nil, problemServerCert},
+		{"ca and pin", fakeCACert, "", []string{otherPin, serverPin}, ""},
+		{"ca and ca pin", fakeCACert, "", []string{caPin}, ""},
+		{"ca and wrong pin", fakeCACert, "", []string{otherPin}, problemServerCert},
+		{"pin only", nil, "wrong.example.com", []string{serverPin}, ""},
+		{"wrong pin only", nil, "", []string{otherPin}, problemServerCert},
+		{"unpresented ca pin only", nil, "", []string{caPin}, problemServerCert},
+	}
+	for _, c := range cases {
+		tlscfg, err := tlsConfig(&Config{
+			CA:         c.ca,
+			ClientCert: fakeClientCert,
+			ClientKey:  fakeClientKey,
+			ServerName: c.serverName,
+			ServerPins: c.pins,
+		})
+		if err != nil {
+			t.Fatalf("%s: tlsConfig: %v", c.name, err)
+		}
+		client, err := dial(srv.Listener.Addr().String(), tlscfg, &Config{})
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+			} else {
+				client.Close()
+			}
+			continue
+		}
+
+		var connErr *connectionError
+		if !errors.As(err, &connErr) || connErr.Problem != c.problem {
+			t.Errorf("%s: expected problem %q, got %v", c.name, c.problem, err)
+			continue
+		}
+		if c.pins != nil && !strings.Contains(err.Error(), "presented sha256/"+serverPin) {
+			t.Errorf("%s: expected error to show the presented pin, got %v", c.name, err)
+		}
+	}
+}
+
+// TestDial_pinnedChain checks a server can't match a pin by presenting the
+// pinned certificate after a leaf of its own.
+func TestDial_pinnedChain(t *testing.T) {
+	serverCert := mustParseCert(t, mustReadFile("testdata/server.crt"))
+	serverPin := spkiPin(serverCert)
+
+	// leaf certificates for a key the pinned server doesn't hold
+	selfSigned := testServerCert(t, nil)
+	caSigned := testServerCert(t, mustLoadKeyPair(t, "testdata/ca.crt", "testdata/ca.key"))
+
+	cases := []struct {
+		name string
+		leaf tls.Certificate
+		ca   []byte
+	}{
+		{"self-signed leaf", selfSigned, nil},
+		{"ca signed leaf", caSigned, nil},
+		{"ca signed leaf and ca", caSigned, fakeCACert},
+	}
+	for _, c := range cases {
+		// present the pinned server certificate after the leaf
+		c.leaf.Certificate = append(c.leaf.Certificate, serverCert.Raw)
+		lis := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{c.leaf}})
+
+		tlscfg, err := tlsConfig(&Config{
+			CA:         c.ca,
+			ClientCert: fakeClientCert,
+			ClientKey:  fakeClientKey,
+			ServerPins: []string{serverPin},
+		})
+		if err != nil {
+			t.Fatalf("%s: tlsConfig: %v", c.name, err)
+		}
+		client, err := dial(lis.Addr().String(), tlscfg, &Config{})
+		lis.Close()
+		if err == nil {
+			client.Close()
+			t.Errorf("%s: expected the connection to be rejected", c.name)
+			continue
+		}
+		var connErr *connectionError
+		if !errors.As(err, &connErr) || connErr.Problem != problemServerCert {
+			t.Errorf("%s: expected problem %q, got %v", c.name, problemServerCert, err)
+		}
+	}
+}
+
+// testServerCert returns a 127.0.0.1 server certificate with a new key,
+// signed by the parent or self-signed if parent is nil.
+func testServerCert(t *testing.T, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "impostor"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	issuer, signer := template, crypto.Signer(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey.(crypto.Signer)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func mustLoadKeyPair(t *testing.T, certFile, keyFile string) *tls.Certificate {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cert
+}
+
+// serveTLS returns a listener which completes TLS handshakes and closes
+// connections.
+func serveTLS(t *testing.T, config *tls.Config) net.Listener {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return lis
+}
+
+// TestDial_tlsPolicy checks the TLS minimum version, cipher suites, and CRL
+// are applied.
+func TestDial_tlsPolicy(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	// TLS 1.2 only server
+	tls12 := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{*mustLoadKeyPair(t, "testdata/server.crt", "testdata/server.key")},
+		MaxVersion:   tls.VersionTLS12,
+	})
+	defer tls12.Close()
+
+	serverCert := mustParseCert(t, mustReadFile("testdata/server.crt"))
+	revoked := testCRL(t, serverCert.SerialNumber)
+	other := testCRL(t, big.NewInt(42))
+	block, _ := pem.Decode(revoked)
+	revokedDER := block.Bytes
+
+	endpoint := srv.Listener.Addr().String()
+	cases := []struct {
+		name         string
+		endpoint     string
+		minVersion   uint16
+		cipherSuites []uint16
+		crl          []byte
+		problem      string
+	}{
+		{"tls 1.3", endpoint, tls.VersionTLS13, nil, nil, ""},
+		{"tls 1.3 unsupported", tls12.Addr().String(), tls.VersionTLS13, nil, nil, problemTLS},
+		{"cipher suites", endpoint, 0, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, nil, ""},
+		{"crl", endpoint, 0, nil, other, ""},
+		{"revoked", endpoint, 0, nil, revoked, problemRevoked},
+		{"revoked der", endpoint, 0, nil, revokedDER, problemRevoked},
+	}
+	for _, c := range cases {
+		tlscfg, err := tlsConfig(&Config{
+			CA:           fakeCACert,
+			ClientCert:   fakeClientCert,
+			ClientKey:    fakeClientKey,
+			MinVersion:   c.minVersion,
+			CipherSuites: c.cipherSuites,
+			CRL:          c.crl,
+		})
+		if err != nil {
+			t.Fatalf("%s: tlsConfig: %v", c.name, err)
+		}
+		client, err := dial(c.endpoint, tlscfg, &Config{})
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+			} else {
+				client.Close()
+			}
+			continue
+		}
+
+		var connErr *connectionError
+		if !errors.As(err, &connErr) || connErr.Problem != c.problem {
+			t.Errorf("%s: expected problem %q, got %v", c.name, c.problem, err)
+		}
+	}
+}
+
+// testCRL returns a PEM encoded CRL, signed by the fixture CA, which revokes
+// the serial number.
+func testCRL(t *testing.T, serial *big.Int) []byte {
+	ca, err := tls.LoadX509KeyPair("testdata/ca.crt", "testdata/ca.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: serial, RevocationTime: time.Now().Add(-time.Hour)},
+		},
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.Leaf, ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestParsePin(t *testing.T) {
+	hash := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	cases := []struct {
+		pin string
+		ok  bool
+	}{
+		{"sha256/" + hash, true},
+		{"sha256//" + hash, true},
+		{hash, true},
+		{"sha256/abc", false},
+		{"sha256/" + hash[:20], false},
+	}
+	for _, c := range cases {
+		got, err := parsePin(c.pin)
+		if c.ok && (err != nil || got != hash) {
+			t.Errorf("parsePin(%q): expected %q, got %q, %v", c.pin, hash, got, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("parsePin(%q): expected error, got nil", c.pin)
+		}
+	}
+}
+
+func mustParseCert(t *testing.T, pemCert []byte) *x509.Certificate {
+	block, _ := pem.Decode(pemCert)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// TestCertExpiryDiagnostics checks certificates which expire within the
+// window warn and expired certificates error.
+func TestCertExpiryDiagnostics(t *testing.T) {
+	window := 30 * 24 * time.Hour
+	valid, _ := selfSignedClient(t, "valid", time.Now().Add(365*24*time.Hour))
+	expiring, _ := selfSignedClient(t, "expiring", time.Now().Add(72*time.Hour))
+	expired, _ := selfSignedClient(t, "expired", time.Now().Add(-time.Hour))
+
+	cases := []struct {
+		name     string
+		pemCerts []byte
+		severity []diag.Severity
+		detail   string
+	}{
+		{"valid", valid, nil, ""},
+		{"expiring", expiring, []diag.Severity{diag.Warning}, `client certificate "CN=expiring" expires`},
+		{"expired", expired, []diag.Severity{diag.Error}, `client certificate "CN=expired" expired`},
+		{"bundle", append(valid, expiring...), []diag.Severity{diag.Warning}, `"CN=expiring"`},
+		{"fixture", fakeClientCert, nil, ""},
+	}
+	for _, c := range cases {
+		diags := certExpiryDiagnostics("client", c.pemCerts, window)
+		if len(diags) != len(c.severity) {
+			t.Errorf("%s: expected %d diagnostics, got %v", c.name, len(c.severity), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.Severity != c.severity[i] {
+				t.Errorf("%s: expected severity %v, got %v", c.name, c.severity[i], d.Severity)
+			}
+			if !strings.Contains(d.Detail, c.detail) {
+				t.Errorf("%s: expected detail %q, got %q", c.name, c.detail, d.Detail)
+			}
+		}
+	}
+}
+
+// selfSignedClient returns a PEM encoded client certificate and key which
+// the fixture server's CA didn't issue.
+func selfSignedClient(t *testing.T, commonName string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,90 @@
+package matchbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is the matchbox client config file used if config_path
+// and MATCHBOX_CONFIG aren't set.
+const defaultConfigPath = "~/.matchbox/config"
+
+// clientConfig is a kubeconfig-style matchbox client config file which lists
+// named contexts.
+//
+//	contexts:
+//	- name: site-a
+//	  endpoint: matchbox.a.example.com:8081
+//	  certificate-authority: site-a/ca.crt
+//	  client-certificate: site-a/client.crt
+//	  client-key: site-a/client.key
+type clientConfig struct {
+	Contexts []clientContext `yaml:"contexts"`
+}
+
+// clientContext is a named Matchbox endpoint and the paths to its credentials.
+// Relative paths are relative to the config file's directory.
+type clientContext struct {
+	Name       string   `yaml:"name"`
+	Endpoint   string   `yaml:"endpoint"`
+	Endpoints  []string `yaml:"endpoints"`
+	CA         string   `yaml:"certificate-authority"`
+	ClientCert string   `yaml:"client-certificate"`
+	ClientKey  string   `yaml:"client-key"`
+}
+
+// loadContext reads the named context from a matchbox client config file.
+func loadContext(path, name string) (*clientContext, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &clientConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, context := range config.Contexts {
+		if context.Name != name {
+			continue
+		}
+		if context.Endpoint != "" && len(context.Endpoints) > 0 {
+			return nil, fmt.Errorf("%s: context %q sets both endpoint and endpoints", path, name)
+		}
+		dir := filepath.Dir(path)
+		context.CA = resolvePath(dir, context.CA)
+		context.ClientCert = resolvePath(dir, context.ClientCert)
+		context.ClientKey = resolvePath(dir, context.ClientKey)
+		return &context, nil
+	}
+	return nil, fmt.Errorf("%s: no context named %q", path, name)
+}
+
+// endpoints returns the context's endpoint or endpoints.
+func (c *clientContext) endpoints() []string {
+	if c.Endpoint != "" {
+		return []string{c.Endpoint}
+	}
+	return c.Endpoints
+}
+
+// resolvePath returns a path relative to dir, unless the path is empty,
+// absolute, or starts with ~.
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) || path == "~" || strings.HasPrefix(path, "~/") {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,89 @@
+package matchbox
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// revokedError is a server certificate revoked by a CRL.
+type revokedError struct {
+	Cert *x509.Certificate
+}
+
+func (e *revokedError) Error() string {
+	return fmt.Sprintf("certificate %q (serial %s) was revoked by %q", e.Cert.Subject.String(), e.Cert.SerialNumber, e.Cert.Issuer.String())
+}
+
+// parseCRLs parses PEM (one or more X509 CRL blocks) or DER encoded
+// certificate revocation lists.
+func parseCRLs(data []byte) ([]*x509.RevocationList, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRL: %v", err)
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+
+	var crls []*x509.RevocationList
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRL: %v", err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, errors.New("invalid CRL: no X509 CRL PEM blocks were parsed")
+	}
+	return crls, nil
+}
+
+// verifyNotRevoked returns a tls.Config VerifyPeerCertificate function which
+// checks no certificate in the server's chain is revoked by the CRLs. CRLs are
+// matched to certificates by issuer and, if the chain was verified against a
+// CA, must be signed by the issuer.
+func verifyNotRevoked(crls []*x509.RevocationList) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		chain := []*x509.Certificate{}
+		if len(verifiedChains) > 0 {
+			chain = verifiedChains[0]
+		} else {
+			// server_pins without a CA
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				chain = append(chain, cert)
+			}
+		}
+
+		for i, cert := range chain {
+			var issuer *x509.Certificate
+			if len(verifiedChains) > 0 && i+1 < len(chain) {
+				issuer = chain[i+1]
+			}
+			for _, crl := range crls {
+				if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+					continue
+				}
+				if issuer != nil && crl.CheckSignatureFrom(issuer) != nil {
+					continue
+				}
+				for _, entry := range crl.RevokedCertificateEntries {
+					if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+						return &revokedError{Cert: cert}
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
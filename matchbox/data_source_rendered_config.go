@@ -0,0 +1,235 @@
+package matchbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	butane "github.com/coreos/butane/config"
+	"github.com/coreos/butane/config/common"
+	v3 "github.com/coreos/ignition/v2/config/v3_4"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+
+	"github.com/poseidon/matchbox/matchbox/server/serverpb"
+)
+
+func dataSourceRenderedConfig() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceRenderedConfigRead,
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"group": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"group", "profile"},
+			},
+			"profile": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"group"},
+			},
+			"selector": {
+				Type:          schema.TypeMap,
+				Optional:      true,
+				Elem:          schema.TypeString,
+				ConflictsWith: []string{"group"},
+			},
+			"metadata": {
+				Type:          schema.TypeMap,
+				Optional:      true,
+				Elem:          schema.TypeString,
+				ConflictsWith: []string{"group"},
+			},
+			"ignition": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ignition_sha256": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"generic": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"generic_sha256": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// dataSourceRenderedConfigRead renders a Profile's Ignition and generic
+// configs for a Group (or a selector and metadata), as matchbox would serve
+// them to a machine the Group matches. Configs are read from the first
+// replica.
+func dataSourceRenderedConfigRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	client := meta.(Replicas)[0]
+
+	profileName := d.Get("profile").(string)
+	selector := map[string]string{}
+	var metadata []byte
+	if name, ok := d.GetOk("group"); ok {
+		groupGetResponse, err := client.Groups.GroupGet(ctx, &serverpb.GroupGetRequest{
+			Id: name.(string),
+		})
+		if err != nil {
+			return rpcDiagnostics(client, "GroupGet", name.(string), err)
+		}
+		group := groupGetResponse.Group
+		profileName = group.Profile
+		selector = group.Selector
+		metadata = group.Metadata
+	} else {
+		for key, value := range d.Get("selector").(map[string]interface{}) {
+			selector[key] = value.(string)
+		}
+		var err error
+		metadata, err = json.Marshal(d.Get("metadata"))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	data, err := templateData(metadata, selector)
+	if err != nil {
+		return diag.Errorf("invalid metadata: %v", err)
+	}
+
+	profileGetResponse, err := client.Profiles.ProfileGet(ctx, &serverpb.ProfileGetRequest{
+		Id: profileName,
+	})
+	if err != nil {
+		return rpcDiagnostics(client, "ProfileGet", profileName, err)
+	}
+	profile := profileGetResponse.Profile
+
+	var ignition, generic string
+	if profile.IgnitionId != "" {
+		ignitionGetResponse, err := client.Ignition.IgnitionGet(ctx, &serverpb.IgnitionGetRequest{
+			Name: profile.IgnitionId,
+		})
+		if err != nil {
+			return rpcDiagnostics(client, "IgnitionGet", profile.IgnitionId, err)
+		}
+		var renderDiags diag.Diagnostics
+		ignition, renderDiags = renderIgnitionConfig(profile.IgnitionId, string(ignitionGetResponse.Config), data)
+		diags = append(diags, renderDiags...)
+		if diags.HasError() {
+			return diags
+		}
+	}
+
+	if profile.GenericId != "" {
+		genericGetResponse, err := client.Generic.GenericGet(ctx, &serverpb.GenericGetRequest{
+			Name: profile.GenericId,
+		})
+		if err != nil {
+			return rpcDiagnostics(client, "GenericGet", profile.GenericId, err)
+		}
+		generic, err = renderTemplate(string(genericGetResponse.Config), data)
+		if err != nil {
+			return append(diags, renderDiagnostic(profile.GenericId, err))
+		}
+	}
+
+	d.SetId(profileName)
+	for key, value := range map[string]string{
+		"profile":         profileName,
+		"ignition":        ignition,
+		"ignition_sha256": sha256Hex(ignition),
+		"generic":         generic,
+		"generic_sha256":  sha256Hex(generic),
+	} {
+		if err := d.Set(key, value); err != nil {
+			return append(diags, diag.FromErr(err)...)
+		}
+	}
+	return diags
+}
+
+// templateData returns the data matchbox renders templates with: a Group's
+// metadata, its selector with lower cased keys, and the request. The request
+// query is taken to be the selector, as sent by a machine the Group matches.
+func templateData(metadata []byte, selector map[string]string) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &data); err != nil {
+			return nil, err
+		}
+	}
+	query := url.Values{}
+	for key, value := range selector {
+		data[strings.ToLower(key)] = value
+		query.Set(key, value)
+	}
+	data[requestKey] = map[string]interface{}{
+		"query":     selector,
+		"raw_query": query.Encode(),
+	}
+	return data, nil
+}
+
+// renderIgnitionConfig renders Ignition like matchbox. Raw Ignition (.ign or
+// .ignition) is parsed and served as is, while Container Linux Config (Butane)
+// templates are rendered with data and translated to Ignition.
+func renderIgnitionConfig(name, content string, data map[string]interface{}) (string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if !strings.HasSuffix(name, ".ign") && !strings.HasSuffix(name, ".ignition") {
+		rendered, err := renderTemplate(content, data)
+		if err != nil {
+			return "", diag.Diagnostics{renderDiagnostic(name, err)}
+		}
+		ignition, rpt, err := butane.TranslateBytes([]byte(rendered), common.TranslateBytesOptions{})
+		if err != nil {
+			return "", diag.Diagnostics{renderDiagnostic(name, fmt.Errorf("failed to translate Butane to Ignition:\n%s", strings.TrimSpace(rpt.String())))}
+		}
+		content = string(ignition)
+	}
+
+	// matchbox serves an empty config if Ignition doesn't parse
+	cfg, rpt, err := v3.ParseCompatibleVersion([]byte(content))
+	if err != nil {
+		detail := err.Error()
+		if len(rpt.Entries) > 0 {
+			detail = strings.TrimSpace(rpt.String())
+		}
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "Matchbox serves an empty Ignition config",
+			Detail:   fmt.Sprintf("%s isn't Ignition matchbox can parse, so machines receive an empty config: %s", name, detail),
+		})
+	}
+	ignition, err := json.Marshal(cfg)
+	if err != nil {
+		return "", append(diags, diag.FromErr(err)...)
+	}
+	return string(ignition), diags
+}
+
+// renderDiagnostic returns an error diagnostic for a config matchbox would
+// fail to render, responding 404 Not Found to machines.
+func renderDiagnostic(name string, err error) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  "Failed to render config",
+		Detail:   fmt.Sprintf("%s: %v\n\nMatchbox would respond 404 Not Found to machines.", name, err),
+	}
+}
+
+// sha256Hex returns the hex SHA-256 digest of content.
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
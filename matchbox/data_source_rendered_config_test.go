@@ -0,0 +1,94 @@
+package matchbox
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+// TestDataSourceRenderedConfig checks configs are rendered with a Group's
+// metadata and selector, as Matchbox would serve them.
+func TestDataSourceRenderedConfig(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	resources := `
+		resource "matchbox_profile" "worker" {
+			name   = "worker"
+			kernel = "foo"
+			container_linux_config = <<-EOT
+				variant: fcos
+				version: 1.5.0
+				storage:
+				  files:
+				    - path: /etc/hostname
+				      contents:
+				        inline: {{.hostname}}
+			EOT
+			generic_config = "mac={{.mac}} query={{.request.raw_query}}"
+		}
+
+		resource "matchbox_group" "node1" {
+			name    = "node1"
+			profile = matchbox_profile.worker.name
+			selector = {
+				MAC = "52:54:00:a1:9c:ae"
+			}
+			metadata = {
+				hostname = "node1"
+			}
+		}
+	`
+	rendered := `
+		data "matchbox_rendered_config" "node1" {
+			group = matchbox_group.node1.name
+		}
+
+		data "matchbox_rendered_config" "node2" {
+			profile = matchbox_profile.worker.name
+			selector = {
+				mac = "52:54:00:b2:2f:86"
+			}
+			metadata = {
+				hostname = "node2"
+			}
+		}
+	`
+	missing := `
+		data "matchbox_rendered_config" "missing" {
+			profile = matchbox_profile.worker.name
+			selector = {
+				mac = "52:54:00:b2:2f:86"
+			}
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      srv.AddProviderConfig(resources + missing),
+				ExpectError: regexp.MustCompile(`map has no\s+entry for key "hostname"`),
+			},
+			{
+				Config: srv.AddProviderConfig(resources + rendered),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.matchbox_rendered_config.node1", "profile", "worker"),
+					resource.TestMatchResourceAttr("data.matchbox_rendered_config.node1", "ignition", regexp.MustCompile(`"path":"/etc/hostname".*"source":"data:,node1"`)),
+					resource.TestCheckResourceAttr("data.matchbox_rendered_config.node1", "generic", "mac=52:54:00:a1:9c:ae query=MAC=52%3A54%3A00%3Aa1%3A9c%3Aae"),
+					resource.TestCheckResourceAttr("data.matchbox_rendered_config.node1", "generic_sha256", sha256Hex("mac=52:54:00:a1:9c:ae query=MAC=52%3A54%3A00%3Aa1%3A9c%3Aae")),
+					resource.TestMatchResourceAttr("data.matchbox_rendered_config.node1", "ignition_sha256", regexp.MustCompile(`^[0-9a-f]{64}$`)),
+					resource.TestMatchResourceAttr("data.matchbox_rendered_config.node2", "ignition", regexp.MustCompile(`"source":"data:,node2"`)),
+					resource.TestCheckResourceAttr("data.matchbox_rendered_config.node2", "generic", "mac=52:54:00:b2:2f:86 query=mac=52%3A54%3A00%3Ab2%3A2f%3A86"),
+				),
+			},
+		},
+	})
+}
@@ -1,13 +1,22 @@
 package matchbox
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/poseidon/matchbox/matchbox/rpc"
 	"github.com/poseidon/matchbox/matchbox/server"
 	"github.com/poseidon/matchbox/matchbox/storage"
+	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
+	"github.com/poseidon/matchbox/matchbox/storage/testfakes"
 	"github.com/poseidon/matchbox/matchbox/tlsutil"
 	"google.golang.org/grpc"
 )
@@ -78,9 +87,23 @@ func (s *FixtureServer) Stop() {
 }
 
 func (s *FixtureServer) AddProviderConfig(hcl string) string {
+	endpoint := fmt.Sprintf(`endpoint = "%s"`, s.Listener.Addr().String())
+	return s.AddProviderConfigWithAttributes(endpoint, hcl)
+}
+
+// AddProviderConfigWithEndpoints returns HCL with a provider configured to use
+// the given endpoints (e.g. to failover to the server).
+func (s *FixtureServer) AddProviderConfigWithEndpoints(endpoints []string, hcl string) string {
+	attrs := fmt.Sprintf(`endpoints = ["%s"]`, strings.Join(endpoints, `", "`))
+	return s.AddProviderConfigWithAttributes(attrs, hcl)
+}
+
+// AddProviderConfigWithAttributes returns HCL with a provider configured with
+// the given attributes and the server's TLS client credentials.
+func (s *FixtureServer) AddProviderConfigWithAttributes(attrs, hcl string) string {
 	provider := `
 		provider "matchbox" {
-			endpoint = "%s"
+			%s
 			client_cert = <<CERT
 %s
 CERT
@@ -95,7 +118,7 @@ CA
 		%s
 		`
 	return fmt.Sprintf(provider,
-		s.Listener.Addr().String(),
+		attrs,
 		s.ClientTLS.Cert,
 		s.ClientTLS.Key,
 		s.ClientTLS.CA,
@@ -110,3 +133,107 @@ func mustReadFile(filename string) []byte {
 	}
 	return contents
 }
+
+// FixedStore is a testfakes.FixedStore which reports missing objects with the
+// errors matchbox's file store returns.
+type FixedStore struct {
+	*testfakes.FixedStore
+}
+
+func NewFixedStore() *FixedStore {
+	return &FixedStore{testfakes.NewFixedStore()}
+}
+
+// errNotExist returns the file store error for a missing object file.
+func errNotExist(path ...string) error {
+	return &fs.PathError{
+		Op:   "open",
+		Path: filepath.Join(append([]string{"/var/lib/matchbox"}, path...)...),
+		Err:  syscall.ENOENT,
+	}
+}
+
+func (s *FixedStore) GroupGet(id string) (*storagepb.Group, error) {
+	group, err := s.FixedStore.GroupGet(id)
+	if err != nil {
+		return nil, errNotExist("groups", id+".json")
+	}
+	return group, nil
+}
+
+func (s *FixedStore) ProfileGet(id string) (*storagepb.Profile, error) {
+	profile, err := s.FixedStore.ProfileGet(id)
+	if err != nil {
+		return nil, errNotExist("profiles", id+".json")
+	}
+	return profile, nil
+}
+
+func (s *FixedStore) IgnitionGet(name string) (string, error) {
+	config, err := s.FixedStore.IgnitionGet(name)
+	if err != nil {
+		return "", errNotExist("ignition", name)
+	}
+	return config, nil
+}
+
+func (s *FixedStore) GenericGet(name string) (string, error) {
+	config, err := s.FixedStore.GenericGet(name)
+	if err != nil {
+		return "", errNotExist("generic", name)
+	}
+	return config, nil
+}
+
+// BreakableStore wraps a storage.Store to return errors on Group and Profile
+// reads or writes, like a matchbox server with an unreadable or read-only data
+// directory.
+type BreakableStore struct {
+	storage.Store
+	BrokenReads  bool
+	BrokenWrites bool
+	// WriteDelay delays Group and Profile writes (e.g. to exceed timeouts)
+	WriteDelay time.Duration
+	writes     sync.WaitGroup
+}
+
+// Wait waits for in-flight (e.g. delayed) Group and Profile writes.
+func (s *BreakableStore) Wait() {
+	s.writes.Wait()
+}
+
+var errBrokenStore = errors.New("store: broken for testing purposes")
+
+func (s *BreakableStore) GroupGet(id string) (*storagepb.Group, error) {
+	if s.BrokenReads {
+		return nil, errBrokenStore
+	}
+	return s.Store.GroupGet(id)
+}
+
+func (s *BreakableStore) ProfileGet(id string) (*storagepb.Profile, error) {
+	if s.BrokenReads {
+		return nil, errBrokenStore
+	}
+	return s.Store.ProfileGet(id)
+}
+
+func (s *BreakableStore) GroupPut(group *storagepb.Group) error {
+	s.writes.Add(1)
+	defer s.writes.Done()
+	time.Sleep(s.WriteDelay)
+	if s.BrokenWrites {
+		return errBrokenStore
+	}
+	return s.Store.GroupPut(group)
+}
+
+func (s *BreakableStore) ProfilePut(profile *storagepb.Profile) error {
+	s.writes.Add(1)
+	defer s.writes.Done()
+	time.Sleep(s.WriteDelay)
+	if s.BrokenWrites {
+		return errBrokenStore
+	}
+	return s.Store.ProfilePut(profile)
+}
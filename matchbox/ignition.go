@@ -0,0 +1,343 @@
+package matchbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	ignerrors "github.com/coreos/ignition/v2/config/shared/errors"
+	"github.com/coreos/ignition/v2/config/util"
+	v3 "github.com/coreos/ignition/v2/config/v3_4"
+	v3types "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/coreos/vcontext/report"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// validateIgnition validates raw Ignition content against the config spec
+// for its ignition.version. Problems are reported with the JSON path of the
+// field at fault. Matchbox parses configs as spec 3.x and serves an empty
+// config for any it can't parse, so spec 2.x content is rejected.
+func validateIgnition(i interface{}, path cty.Path) diag.Diagnostics {
+	raw := []byte(i.(string))
+
+	// reports JSON syntax errors with their line and column
+	version, rpt, err := util.GetConfigVersion(raw)
+	if err != nil {
+		diags := ignitionDiagnostics(rpt, path)
+		if !diags.HasError() {
+			diags = append(diags, ignitionError(err, path))
+		}
+		return diags
+	}
+	if version.Major != 3 {
+		return diag.Diagnostics{ignitionError(unsupportedIgnitionVersion(version.String()), path)}
+	}
+
+	_, rpt, err = v3.ParseCompatibleVersion(raw)
+	if errors.Is(err, ignerrors.ErrUnknownVersion) {
+		err = unsupportedIgnitionVersion(version.String())
+	}
+	diags := ignitionDiagnostics(rpt, path)
+	if err != nil && !diags.HasError() {
+		diags = append(diags, ignitionError(err, path))
+	}
+	return diags
+}
+
+// unsupportedIgnitionVersion returns an error for an Ignition spec version
+// matchbox can't serve.
+func unsupportedIgnitionVersion(version string) error {
+	return fmt.Errorf("unsupported Ignition version %s, matchbox serves spec 3.0.0 to %s configs", version, v3types.MaxVersion.String())
+}
+
+// ignitionError returns a diagnostic for an Ignition config which couldn't be
+// parsed.
+func ignitionError(err error, path cty.Path) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity:      diag.Error,
+		Summary:       "Invalid Ignition config",
+		Detail:        err.Error(),
+		AttributePath: path,
+	}
+}
+
+// ignitionDiagnostics converts an Ignition spec 3.x validation report into
+// diagnostics, omitting informational entries.
+func ignitionDiagnostics(rpt report.Report, path cty.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for _, entry := range rpt.Entries {
+		switch entry.Kind {
+		case report.Error:
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       "Invalid Ignition config",
+				Detail:        ignitionEntryDetail(entry),
+				AttributePath: path,
+			})
+		case report.Warn:
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Warning,
+				Summary:       "Ignition config warning",
+				Detail:        ignitionEntryDetail(entry),
+				AttributePath: path,
+			})
+		}
+	}
+	return diags
+}
+
+// ignitionEntryDetail describes a report entry with its JSON path and
+// location (e.g. at $.storage.files.0.path, line 1 col 42: ...).
+func ignitionEntryDetail(entry report.Entry) string {
+	var at []string
+	if entry.Context.Len() > 0 {
+		at = append(at, entry.Context.String())
+	}
+	if entry.Marker.StartP != nil && entry.Marker.StartP.Line > 0 {
+		at = append(at, entry.Marker.String())
+	}
+	if len(at) == 0 {
+		return entry.Message
+	}
+	return fmt.Sprintf("at %s: %s", strings.Join(at, ", "), entry.Message)
+}
+
+// suppressEquivalentIgnition is a DiffSuppressFunc which ignores Ignition
+// reformatting (e.g. key order and whitespace), so content rendered by
+// different tools or versions doesn't plan an update.
+func suppressEquivalentIgnition(k, old, new string, d *schema.ResourceData) bool {
+	oldConfig, err := decodeJSON(old)
+	if err != nil {
+		return false
+	}
+	newConfig, err := decodeJSON(new)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(oldConfig, newConfig)
+}
+
+// ignitionChanges describes the fields which differ between two Ignition
+// configs (e.g. storage.files[3].contents changed), in path order. Nil is
+// returned if either config isn't JSON.
+func ignitionChanges(old, new string) []string {
+	oldConfig, err := decodeJSON(old)
+	if err != nil {
+		return nil
+	}
+	newConfig, err := decodeJSON(new)
+	if err != nil {
+		return nil
+	}
+	var changes []string
+	diffJSON("", oldConfig, newConfig, &changes)
+	return changes
+}
+
+// decodeJSON decodes JSON, keeping numbers exact.
+func decodeJSON(content string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected content after JSON value")
+	}
+	return v, nil
+}
+
+// diffJSON appends a description of each difference between decoded JSON
+// values under the path.
+func diffJSON(path string, old, new interface{}, changes *[]string) {
+	switch oldValue := old.(type) {
+	case map[string]interface{}:
+		newValue, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for key := range oldValue {
+			keys[key] = true
+		}
+		for key := range newValue {
+			keys[key] = true
+		}
+		for _, key := range slices.Sorted(maps.Keys(keys)) {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			o, inOld := oldValue[key]
+			n, inNew := newValue[key]
+			switch {
+			case !inOld:
+				*changes = append(*changes, field+" added")
+			case !inNew:
+				*changes = append(*changes, field+" removed")
+			default:
+				diffJSON(field, o, n, changes)
+			}
+		}
+		return
+	case []interface{}:
+		newValue, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(oldValue), len(newValue)); i++ {
+			element := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(oldValue):
+				*changes = append(*changes, element+" added")
+			case i >= len(newValue):
+				*changes = append(*changes, element+" removed")
+			default:
+				diffJSON(element, oldValue[i], newValue[i], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		if path == "" {
+			path = "config"
+		}
+		*changes = append(*changes, path+" changed")
+	}
+}
+
+// mergeIgnition merges Ignition spec 3.x fragments, in order, into one config
+// with Ignition's merge semantics: structs merge, later scalars override
+// earlier ones, and lists append (or merge entries with the same key, such as
+// files with the same path). Fields which a later fragment overrides with a
+// different value are returned as conflicts.
+func mergeIgnition(fragments []string) (config string, conflicts []string, err error) {
+	var merged v3types.Config
+	set := map[string]ignitionField{}
+	for i, fragment := range fragments {
+		version, _, err := util.GetConfigVersion([]byte(fragment))
+		if err != nil {
+			return "", nil, fmt.Errorf("ignition_fragments[%d]: %v", i, err)
+		}
+		if version.Major != 3 {
+			return "", nil, fmt.Errorf("ignition_fragments[%d]: Ignition version %s fragments can't be merged, expected 3.x", i, version.String())
+		}
+		cfg, rpt, err := v3.ParseCompatibleVersion([]byte(fragment))
+		if err != nil {
+			var problems []string
+			for _, entry := range rpt.Entries {
+				if entry.Kind == report.Error {
+					problems = append(problems, ignitionEntryDetail(entry))
+				}
+			}
+			if len(problems) == 0 {
+				problems = append(problems, err.Error())
+			}
+			return "", nil, fmt.Errorf("ignition_fragments[%d]: %s", i, strings.Join(problems, "\n"))
+		}
+
+		fields := map[string]string{}
+		flattenIgnition(reflect.ValueOf(cfg), "", fields)
+		for _, field := range slices.Sorted(maps.Keys(fields)) {
+			if field == "ignition.version" {
+				continue
+			}
+			value := fields[field]
+			if prior, ok := set[field]; ok && prior.value != value {
+				conflicts = append(conflicts, fmt.Sprintf("%s is set by ignition_fragments[%d] and overridden by ignition_fragments[%d]", field, prior.fragment, i))
+			}
+			set[field] = ignitionField{fragment: i, value: value}
+		}
+		merged = v3.Merge(merged, cfg)
+	}
+
+	merged.Ignition.Version = v3types.MaxVersion.String()
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return "", nil, err
+	}
+	// Ignition types marshal unset structs as empty objects
+	decoded, err := decodeJSON(string(content))
+	if err != nil {
+		return "", nil, err
+	}
+	content, err = json.Marshal(pruneJSON(decoded))
+	if err != nil {
+		return "", nil, err
+	}
+	return string(content), conflicts, nil
+}
+
+// pruneJSON removes empty objects from a decoded JSON value.
+func pruneJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			field = pruneJSON(field)
+			if object, ok := field.(map[string]interface{}); ok && len(object) == 0 {
+				delete(value, key)
+				continue
+			}
+			value[key] = field
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = pruneJSON(element)
+		}
+	}
+	return v
+}
+
+// ignitionField is the value of an Ignition field and the fragment setting it.
+type ignitionField struct {
+	fragment int
+	value    string
+}
+
+// flattenIgnition records the set scalar fields of an Ignition config by
+// path. Struct list entries are identified by their merge key (e.g.
+// storage.files[/etc/hostname].mode), so fields are comparable across
+// fragments. Scalar lists are appended when merging, so aren't recorded.
+func flattenIgnition(v reflect.Value, path string, fields map[string]string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			if v.Elem().Kind() == reflect.Struct {
+				flattenIgnition(v.Elem(), path, fields)
+			} else {
+				fields[path] = fmt.Sprint(v.Elem().Interface())
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+			field := name
+			switch {
+			case v.Type().Field(i).Anonymous:
+				// embedded structs (e.g. a File's Node) share the path
+				field = path
+			case path != "":
+				field = path + "." + name
+			}
+			flattenIgnition(v.Field(i), field, fields)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if entry, ok := v.Index(i).Interface().(util.Keyed); ok && v.Index(i).Kind() == reflect.Struct {
+				flattenIgnition(v.Index(i), fmt.Sprintf("%s[%s]", path, entry.Key()), fields)
+			}
+		}
+	default:
+		if !v.IsZero() {
+			fields[path] = fmt.Sprint(v.Interface())
+		}
+	}
+}
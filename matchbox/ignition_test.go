@@ -0,0 +1,113 @@
+package matchbox
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+func TestValidateIgnition(t *testing.T) {
+	cases := []struct {
+		raw      string
+		severity diag.Severity
+		detail   string
+	}{
+		{`{"ignition":{"version":"3.3.0"}}`, -1, ""},
+		{`{"ignition":{"version":"3.0.0"},"storage":{"files":[{"path":"/etc/hostname"}]}}`, -1, ""},
+		{`{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"etc/hostname"}]}}`, diag.Error, "at $.storage.files.0.path, line 1 col 61: path not absolute"},
+		{`{"ignition":{"version":"3.3.0"},"foo":1}`, diag.Warning, "at $.foo, line 1 col 33: Unused key foo"},
+		{`baz`, diag.Error, "at line 1 col 2: invalid character 'b'"},
+		{`{"ignition":{"version":"3.3.0"},`, diag.Error, "unexpected end of JSON input"},
+		{`{"ignition":{"version":"3.5.0-experimental"}}`, diag.Error, "unsupported Ignition version 3.5.0-experimental, matchbox serves spec 3.0.0 to 3.4.0 configs"},
+		{`{"ignition":{"version":"2.2.0"}}`, diag.Error, "unsupported Ignition version 2.2.0, matchbox serves spec 3.0.0 to 3.4.0 configs"},
+		{`{"ignition":{"version":"4.0.0"}}`, diag.Error, "unsupported Ignition version 4.0.0"},
+	}
+	path := cty.GetAttrPath("raw_ignition")
+	for _, c := range cases {
+		diags := validateIgnition(c.raw, path)
+		if c.detail == "" {
+			if len(diags) != 0 {
+				t.Errorf("validateIgnition(%s): expected no diagnostics, got %v", c.raw, diags)
+			}
+			continue
+		}
+		if len(diags) != 1 {
+			t.Errorf("validateIgnition(%s): expected one diagnostic, got %v", c.raw, diags)
+			continue
+		}
+		d := diags[0]
+		if d.Severity != c.severity || !strings.Contains(d.Detail, c.detail) || !d.AttributePath.Equals(path) {
+			t.Errorf("validateIgnition(%s): expected %v %q at %v, got %v %q at %v", c.raw, c.severity, c.detail, path, d.Severity, d.Detail, d.AttributePath)
+		}
+	}
+}
+
+func TestIgnitionChanges(t *testing.T) {
+	files := `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/a"},{"path":"/b","contents":{"source":"data:,b"}}]}}`
+	cases := []struct {
+		old        string
+		new        string
+		equivalent bool
+		expected   []string
+	}{
+		{files, "{\n  \"storage\": {\"files\": [{\"path\": \"/a\"}, {\"contents\": {\"source\": \"data:,b\"}, \"path\": \"/b\"}]},\n  \"ignition\": {\"version\": \"3.3.0\"}\n}", true, nil},
+		{files, `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/a"},{"path":"/b","contents":{"source":"data:,c"}}]}}`, false, []string{"storage.files[1].contents.source changed"}},
+		{files, `{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"/a","mode":420}]}}`, false, []string{"ignition.version changed", "storage.files[0].mode added", "storage.files[1] removed"}},
+		{files, `{"ignition":{"version":"3.3.0"}}`, false, []string{"storage removed"}},
+		{`{"ignition":{"version":"3.3.0"},"storage":{"files":[]}}`, `{"ignition":{"version":"3.3.0"},"storage":{"files":{}}}`, false, []string{"storage.files changed"}},
+		{files, `baz`, false, nil},
+	}
+	for _, c := range cases {
+		changes := ignitionChanges(c.old, c.new)
+		if !slices.Equal(changes, c.expected) {
+			t.Errorf("ignitionChanges(%s, %s): expected %q, got %q", c.old, c.new, c.expected, changes)
+		}
+		if suppress := suppressEquivalentIgnition("raw_ignition", c.old, c.new, nil); suppress != c.equivalent {
+			t.Errorf("suppressEquivalentIgnition(%s, %s): expected %v, got %v", c.old, c.new, c.equivalent, suppress)
+		}
+	}
+}
+
+func TestMergeIgnition(t *testing.T) {
+	base := `{"ignition":{"version":"3.3.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["ssh-ed25519 A"]}]},"storage":{"files":[{"path":"/etc/motd","mode":420}]}}`
+	storage := `{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"/etc/fstab"},{"path":"/etc/motd","mode":384}]}}`
+	kubelet := `{"ignition":{"version":"3.4.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["ssh-ed25519 B"]}]},"systemd":{"units":[{"name":"kubelet.service","enabled":true}]}}`
+
+	config, conflicts, err := mergeIgnition([]string{base, storage, kubelet})
+	if err != nil {
+		t.Fatalf("mergeIgnition: %v", err)
+	}
+	for _, expected := range []string{
+		`"version":"3.4.0"`,
+		`"sshAuthorizedKeys":["ssh-ed25519 A","ssh-ed25519 B"]`,
+		`{"mode":384,"path":"/etc/motd"}`,
+		`{"path":"/etc/fstab"}`,
+		`"name":"kubelet.service"`,
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("expected merged config to contain %s, got %s", expected, config)
+		}
+	}
+	expectedConflicts := []string{"storage.files[/etc/motd].mode is set by ignition_fragments[0] and overridden by ignition_fragments[1]"}
+	if !slices.Equal(conflicts, expectedConflicts) {
+		t.Errorf("expected conflicts %q, got %q", expectedConflicts, conflicts)
+	}
+
+	cases := []struct {
+		fragments []string
+		expected  string
+	}{
+		{[]string{base, `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"etc/motd"}]}}`}, "ignition_fragments[1]: at $.storage.files.0.path, line 1 col 61: path not absolute"},
+		{[]string{`{"ignition":{"version":"2.2.0"}}`}, "ignition_fragments[0]: Ignition version 2.2.0 fragments can't be merged"},
+		{[]string{base, `baz`}, "ignition_fragments[1]:"},
+	}
+	for _, c := range cases {
+		_, _, err := mergeIgnition(c.fragments)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("mergeIgnition(%q): expected error %q, got %v", c.fragments, c.expected, err)
+		}
+	}
+}
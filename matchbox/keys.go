@@ -0,0 +1,69 @@
+package matchbox
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// decryptKey returns a PEM encoded private key, decrypting it with the
+// passphrase if needed. Encrypted PKCS#8 keys and legacy OpenSSL encrypted
+// PEM keys are supported. Unencrypted keys are returned as-is.
+func decryptKey(pemKey, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return pemKey, nil
+	}
+
+	encrypted := block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block) //nolint:staticcheck
+	if !encrypted {
+		return pemKey, nil
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("client key is encrypted, but no client_key_passphrase was set")
+	}
+
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt client key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	// legacy encrypted PEM (i.e. Proc-Type: 4,ENCRYPTED) is insecure, but is
+	// still written by older OpenSSL tools
+	der, err := x509.DecryptPEMBlock(block, passphrase) //nolint:staticcheck
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt client key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// decodePKCS12 returns the PEM encoded client certificate, private key, and
+// CA certificates from a PKCS#12 bundle.
+func decodePKCS12(bundle []byte, password string) (cert, key, ca []byte, err error) {
+	privateKey, certificate, caCerts, err := pkcs12.DecodeChain(bundle, password)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode PKCS#12 bundle: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	for _, caCert := range caCerts {
+		ca = append(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})...)
+	}
+	return cert, key, ca, nil
+}
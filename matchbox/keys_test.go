@@ -0,0 +1,107 @@
+package matchbox
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestDecryptKey(t *testing.T) {
+	encrypted := encryptedKey(t, "secret")
+	legacy := legacyEncryptedKey(t, "secret")
+
+	cases := []struct {
+		name       string
+		key        []byte
+		passphrase string
+		ok         bool
+	}{
+		{"unencrypted", fakeClientKey, "", true},
+		{"unencrypted with passphrase", fakeClientKey, "secret", true},
+		{"pkcs8", encrypted, "secret", true},
+		{"pkcs8 wrong passphrase", encrypted, "wrong", false},
+		{"pkcs8 no passphrase", encrypted, "", false},
+		{"legacy", legacy, "secret", true},
+		{"legacy wrong passphrase", legacy, "wrong", false},
+	}
+	for _, c := range cases {
+		key, err := decryptKey(c.key, []byte(c.passphrase))
+		if !c.ok {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", c.name, err)
+			continue
+		}
+		if _, err := tls.X509KeyPair(fakeClientCert, key); err != nil {
+			t.Errorf("%s: decrypted key doesn't match the client certificate: %v", c.name, err)
+		}
+	}
+}
+
+func TestDecodePKCS12(t *testing.T) {
+	bundle := pkcs12Bundle(t, "secret")
+
+	cert, key, ca, err := decodePKCS12(bundle, "secret")
+	if err != nil {
+		t.Fatalf("decodePKCS12: %v", err)
+	}
+	if _, err := tlsConfig(&Config{CA: ca, ClientCert: cert, ClientKey: key}); err != nil {
+		t.Errorf("expected bundle to provide TLS credentials, got %v", err)
+	}
+
+	if _, _, _, err := decodePKCS12(bundle, "wrong"); err == nil {
+		t.Errorf("expected error decoding with the wrong password, got nil")
+	}
+}
+
+// encryptedKey returns the fixture client key as an encrypted PKCS#8 key.
+func encryptedKey(t *testing.T, passphrase string) []byte {
+	key, err := tls.X509KeyPair(fakeClientCert, fakeClientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := pkcs8.MarshalPrivateKey(key.PrivateKey, []byte(passphrase), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})
+}
+
+// legacyEncryptedKey returns the fixture client key as a legacy OpenSSL
+// encrypted PEM key.
+func legacyEncryptedKey(t *testing.T, passphrase string) []byte {
+	block, _ := pem.Decode(fakeClientKey)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "PRIVATE KEY", block.Bytes, []byte(passphrase), x509.PEMCipherAES256) //nolint:staticcheck
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// pkcs12Bundle returns the fixture client certificate, key, and CA as a
+// PKCS#12 bundle.
+func pkcs12Bundle(t *testing.T, password string) []byte {
+	key, err := tls.X509KeyPair(fakeClientCert, fakeClientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBlock, _ := pem.Decode(fakeCACert)
+	ca, err := x509.ParseCertificate(caBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := pkcs12.Modern.Encode(key.PrivateKey, key.Leaf, []*x509.Certificate{ca}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
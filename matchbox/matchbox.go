@@ -1,62 +1,306 @@
 package matchbox
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
 
-	matchbox "github.com/poseidon/matchbox/matchbox/client"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/poseidon/matchbox/matchbox/rpc/rpcpb"
+	"github.com/poseidon/matchbox/matchbox/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
-var (
-	defaultTimeout = 25 * time.Second
+const (
+	// defaultDialTimeout is the default timeout to connect to an endpoint
+	defaultDialTimeout = 25 * time.Second
+	// defaultResourceTimeout is the default timeout for resource operations
+	defaultResourceTimeout = 5 * time.Minute
 )
 
 // Config configures a matchbox client.
 type Config struct {
-	// gRPC API endpoint
-	Endpoint string
+	// gRPC API endpoints (host:port or unix:///path), tried in order
+	Endpoints []string
+	// ProxyURL is an HTTP CONNECT or SOCKS5 proxy to reach endpoints through.
+	// If empty, HTTPS_PROXY or ALL_PROXY are used.
+	ProxyURL string
 	// PEM encoded TLS CA and client credentials
 	CA         []byte
 	ClientCert []byte
 	ClientKey  []byte
+	// ServerName overrides the hostname used to verify the server certificate
+	ServerName string
+	// ServerPins are base64 SHA-256 hashes of SubjectPublicKeyInfo, one of
+	// which the server certificate chain must match. If CA is empty, only
+	// the pins are checked.
+	ServerPins []string
+	// MinVersion is the minimum TLS version (default TLS 1.2)
+	MinVersion uint16
+	// CipherSuites are the allowed TLS 1.2 cipher suites (default Go's)
+	CipherSuites []uint16
+	// PEM or DER encoded CRLs which revoke server certificates
+	CRL []byte
+	// DialTimeout bounds connecting to an endpoint (default 25s)
+	DialTimeout time.Duration
+	// Retry configures retrying RPCs with transient failures
+	Retry RetryPolicy
 }
 
-// NewMatchboxClient returns a new matchbox.Client.
-func NewMatchboxClient(config *Config) (*matchbox.Client, error) {
-	tlscfg, err := tlsConfig(config.CA, config.ClientCert, config.ClientKey)
+// Client provides matchbox API clients for an endpoint or data directory.
+type Client struct {
+	Groups   rpcpb.GroupsClient
+	Profiles rpcpb.ProfilesClient
+	Ignition rpcpb.IgnitionClient
+	Generic  rpcpb.GenericClient
+	// gRPC API endpoint or data directory
+	Endpoint string
+	// close closes connections, if any
+	close func() error
+}
+
+// Close closes the Client's connections.
+func (c *Client) Close() error {
+	if c.close == nil {
+		return nil
+	}
+	return c.close()
+}
+
+// NewMatchboxClient returns a new Client connected to the first reachable
+// endpoint. If no endpoint can be reached, the error lists the failure for
+// each endpoint.
+func NewMatchboxClient(config *Config) (*Client, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("no endpoints provided")
+	}
+
+	tlscfg, err := tlsConfig(config)
 	if err != nil {
 		return nil, err
 	}
-	return matchbox.New(&matchbox.Config{
-		Endpoints:   []string{config.Endpoint},
-		DialTimeout: defaultTimeout,
-		TLS:         tlscfg,
-	})
+
+	var errs []error
+	for _, endpoint := range config.Endpoints {
+		client, err := dial(endpoint, tlscfg, config)
+		if err == nil {
+			return client, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// NewMatchboxReplicas returns Replicas with a Client connected to every
+// endpoint. If any endpoint can't be reached, the error lists the failure for
+// each unreachable endpoint.
+func NewMatchboxReplicas(config *Config) (Replicas, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("no endpoints provided")
+	}
+
+	tlscfg, err := tlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var replicas Replicas
+	var errs []error
+	for _, endpoint := range config.Endpoints {
+		client, err := dial(endpoint, tlscfg, config)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		replicas = append(replicas, client)
+	}
+	if len(errs) > 0 {
+		for _, client := range replicas {
+			client.Close()
+		}
+		return nil, errors.Join(errs...)
+	}
+	return replicas, nil
+}
+
+// dial returns a Client connected to the endpoint, directly or through a
+// proxy. The connection is checked with an authenticated RPC, so
+// misconfigurations fail with a classified connectionError rather than on
+// the first resource change.
+func dial(endpoint string, tlscfg *tls.Config, config *Config) (*Client, error) {
+	timeout := config.DialTimeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, _, host, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, &connectionError{Endpoint: endpoint, Problem: problemEndpoint, Err: err}
+	}
+	tlscfg = tlscfg.Clone()
+	if tlscfg.ServerName == "" {
+		tlscfg.ServerName = host
+	}
+
+	dialer := dialContext(config.ProxyURL)
+	if err := checkConnection(ctx, endpoint, dialer, tlscfg); err != nil {
+		return nil, err
+	}
+
+	// passthrough leaves resolving endpoints to the dialer (or proxy)
+	conn, err := grpc.NewClient("passthrough:///"+endpoint,
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlscfg)),
+		grpc.WithUnaryInterceptor(retryInterceptor(config.Retry)),
+	)
+	if err != nil {
+		return nil, &connectionError{Endpoint: endpoint, Problem: problemEndpoint, Err: err}
+	}
+	client := &Client{
+		Groups:   rpcpb.NewGroupsClient(conn),
+		Profiles: rpcpb.NewProfilesClient(conn),
+		Ignition: rpcpb.NewIgnitionClient(conn),
+		Generic:  rpcpb.NewGenericClient(conn),
+		Endpoint: endpoint,
+		close:    conn.Close,
+	}
+	// fail fast, rather than retry, when checking the connection
+	if err := probe(withoutRetry(ctx), client, tlscfg); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
 }
 
 // tlsConfig returns a matchbox client TLS.Config.
 // TODO: Update matchbox TLSInfo.ClientConfig to replace this.
-func tlsConfig(ca, clientCert, clientKey []byte) (*tls.Config, error) {
+func tlsConfig(config *Config) (*tls.Config, error) {
+	// client certificate for authentication
+	cert, err := tls.X509KeyPair(config.ClientCert, config.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlscfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: config.CipherSuites,
+		// Client certificate to authenticate to the server
+		Certificates: []tls.Certificate{cert},
+		ServerName:   config.ServerName,
+	}
+	if config.MinVersion != 0 {
+		tlscfg.MinVersion = config.MinVersion
+	}
+
+	// additional server certificate checks
+	var verifiers []func([][]byte, [][]*x509.Certificate) error
+	if len(config.ServerPins) > 0 {
+		verifiers = append(verifiers, verifyPins(config.ServerPins))
+	}
+	if len(config.CRL) > 0 {
+		crls, err := parseCRLs(config.CRL)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, verifyNotRevoked(crls))
+	}
+	if len(verifiers) > 0 {
+		tlscfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, verify := range verifiers {
+				if err := verify(rawCerts, verifiedChains); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if len(config.ServerPins) > 0 && len(config.CA) == 0 {
+		// pins replace CA verification
+		tlscfg.InsecureSkipVerify = true
+		return tlscfg, nil
+	}
+
 	// certificate authority for verifying the server
 	pool := x509.NewCertPool()
-	ok := pool.AppendCertsFromPEM(ca)
+	ok := pool.AppendCertsFromPEM(config.CA)
 	if !ok {
 		return nil, errors.New("no PEM certificates were parsed")
 	}
+	// CA bundle the client should trust when verifying the server
+	tlscfg.RootCAs = pool
+	return tlscfg, nil
+}
 
-	// client certificate for authentication
-	cert, err := tls.X509KeyPair(clientCert, clientKey)
-	if err != nil {
-		return nil, err
+// notFoundMessages are error messages matchbox storage returns for missing
+// objects. Matchbox only uses codes.NotFound for Group selection, storage
+// errors are returned as codes.Unknown.
+var notFoundMessages = []string{
+	storage.ErrGroupNotFound.Error(),
+	storage.ErrProfileNotFound.Error(),
+}
+
+// notFoundFile matches the error matchbox's file store returns when reading a
+// missing object file (e.g. open /var/lib/matchbox/profiles/worker.json: no
+// such file or directory). A server whose data directory is missing (e.g.
+// unmounted) returns the same error, so its objects appear missing too.
+var notFoundFile = regexp.MustCompile(`^open .*[/\\](groups|profiles|ignition|generic)[/\\][^/\\]+: (no such file or directory|The system cannot find the file specified\.)$`)
+
+// isNotFound returns true if the error from a matchbox RPC indicates the
+// requested object doesn't exist. Transport, authentication, and other server
+// errors return false.
+func isNotFound(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
 	}
+	switch st.Code() {
+	case codes.NotFound:
+		return true
+	case codes.Unknown:
+		if notFoundFile.MatchString(st.Message()) {
+			return true
+		}
+		for _, msg := range notFoundMessages {
+			if st.Message() == msg {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	return &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		// CA bundle the client should trust when verifying the server
-		RootCAs: pool,
-		// Client certificate to authenticate to the server
-		Certificates: []tls.Certificate{cert},
-	}, nil
+// rpcDiagnostics returns error diagnostics for a failed matchbox RPC naming
+// the endpoint, RPC, and object involved.
+func rpcDiagnostics(client *Client, rpc, name string, err error) diag.Diagnostics {
+	detail := fmt.Sprintf("%s %q on %s: %v", rpc, name, client.Endpoint, err)
+	if status.Code(err) == codes.DeadlineExceeded {
+		detail += "\n\nThe operation exceeded its timeout, which can be increased in the resource's timeouts block."
+	}
+	return diag.Diagnostics{{
+		Severity: diag.Error,
+		Summary:  fmt.Sprintf("Matchbox %s failed", rpc),
+		Detail:   detail,
+	}}
+}
+
+// resourceTimeouts returns the default timeouts for resource operations,
+// which span every RPC (and retry) an operation makes across replicas.
+func resourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Create: schema.DefaultTimeout(defaultResourceTimeout),
+		Read:   schema.DefaultTimeout(defaultResourceTimeout),
+		Update: schema.DefaultTimeout(defaultResourceTimeout),
+		Delete: schema.DefaultTimeout(defaultResourceTimeout),
+	}
 }
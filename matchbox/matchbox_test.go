@@ -0,0 +1,34 @@
+package matchbox
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsNotFound(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{status.Error(codes.NotFound, "matchbox: No matching Group"), true},
+		{status.Error(codes.Unknown, "open /var/lib/matchbox/profiles/worker.json: no such file or directory"), true},
+		{status.Error(codes.Unknown, `open C:\matchbox\ignition\worker.ign: The system cannot find the file specified.`), true},
+		{status.Error(codes.Unknown, "storage: No Profile found"), true},
+		{status.Error(codes.Unknown, "open /etc/matchbox/ca.crt: no such file or directory"), false},
+		{status.Error(codes.Unknown, "certificate not found"), false},
+		{status.Error(codes.Unknown, "route not found"), false},
+		{status.Error(codes.Unknown, "unexpected end of JSON input"), false},
+		{status.Error(codes.Unavailable, "connection error: desc = \"transport: Error while dialing\""), false},
+		{status.Error(codes.DeadlineExceeded, "context deadline exceeded"), false},
+		{errors.New("no such file or directory"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := isNotFound(c.err); got != c.expected {
+			t.Errorf("isNotFound(%v): expected %t, got %t", c.err, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package matchbox
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pinError is a server certificate chain which matched none of the pins.
+type pinError struct {
+	// Presented are the pins of the certificates the server presented
+	Presented []string
+	// Cert is the server's leaf certificate
+	Cert *x509.Certificate
+}
+
+func (e *pinError) Error() string {
+	return fmt.Sprintf("server certificate chain matched no server_pins, presented sha256/%s", strings.Join(e.Presented, ", sha256/"))
+}
+
+// spkiPin returns the base64 SHA-256 hash of a certificate's
+// SubjectPublicKeyInfo.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parsePin parses a pin in sha256/BASE64 (or curl's sha256//BASE64) form and
+// returns the base64 hash.
+func parsePin(pin string) (string, error) {
+	hash := strings.TrimPrefix(strings.TrimPrefix(pin, "sha256/"), "/")
+	sum, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("invalid pin %q, expected sha256/ and a base64 SHA-256 hash", pin)
+	}
+	return hash, nil
+}
+
+// verifyPins returns a tls.Config VerifyPeerCertificate function which checks
+// a certificate in the server's chain matches one of the pins. With a CA, only
+// certificates in chains verified to the CA can match. Without one, the leaf
+// or a certificate which signed the presented chain up to the leaf must match,
+// since the handshake only proves the server holds the leaf's key.
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var chains [][]*x509.Certificate
+		if len(verifiedChains) > 0 {
+			chains = verifiedChains
+		} else {
+			chain, err := presentedChain(rawCerts)
+			if err != nil {
+				return err
+			}
+			chains = [][]*x509.Certificate{chain}
+		}
+
+		perr := &pinError{}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if perr.Cert == nil {
+					perr.Cert = cert
+				}
+				presented := spkiPin(cert)
+				for _, pin := range pins {
+					if presented == pin {
+						return nil
+					}
+				}
+				if !slices.Contains(perr.Presented, presented) {
+					perr.Presented = append(perr.Presented, presented)
+				}
+			}
+		}
+		return perr
+	}
+}
+
+// presentedChain returns the leaf certificate the server presented, followed
+// by each presented certificate which signed the one before it. Certificates
+// after the first which didn't sign its predecessor are ignored.
+func presentedChain(rawCerts [][]byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		if len(chain) > 0 && chain[len(chain)-1].CheckSignatureFrom(cert) != nil {
+			break
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
@@ -1,9 +1,19 @@
 package matchbox
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"google.golang.org/grpc/codes"
 )
 
 // Provider returns a Provider for Matchbox.
@@ -11,46 +21,535 @@ func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"endpoint": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"endpoints", "data_path", "context"},
+			},
+			"endpoints": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Optional:      true,
+				ConflictsWith: []string{"endpoint", "data_path", "context"},
+			},
+			"replicate": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"data_path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"endpoint", "endpoints", "context"},
+			},
+			"context": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"endpoint", "endpoints", "data_path"},
+			},
+			"config_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MATCHBOX_CONFIG", defaultConfigPath),
+			},
+			"proxy_url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateProxy,
+			},
+			"tls_server_name": {
 				Type:     schema.TypeString,
-				Required: true,
+				Optional: true,
+			},
+			"server_pins": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validatePin,
+				},
+				Optional: true,
+			},
+			"tls_min_version": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "1.2",
+				ValidateFunc: validation.StringInSlice([]string{"1.2", "1.3"}, false),
+			},
+			"tls_cipher_suites": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateCipherSuite,
+				},
+				Optional: true,
+			},
+			"crl": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"crl_file"},
+			},
+			"crl_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"crl"},
+			},
+			"dial_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "25s",
+				ValidateFunc: validatePositiveDuration,
+			},
+			"retry_max_attempts": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      3,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"retry_backoff": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "1s",
+				ValidateFunc: validatePositiveDuration,
+			},
+			"retry_max_backoff": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "10s",
+				ValidateFunc: validateDuration,
+			},
+			"retry_codes": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateCode,
+				},
+				Optional: true,
+			},
+			"cert_expiry_warning": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "720h",
+				ValidateFunc: validateDuration,
 			},
 			"client_cert": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_cert_file", "pkcs12_file"},
+			},
+			"client_cert_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_cert", "pkcs12_file"},
 			},
 			"client_key": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_key_file", "pkcs12_file"},
+			},
+			"client_key_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_key", "pkcs12_file"},
+			},
+			"client_key_passphrase": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("MATCHBOX_CLIENT_KEY_PASSPHRASE", nil),
+			},
+			"pkcs12_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_cert", "client_cert_file", "client_key", "client_key_file"},
+			},
+			"pkcs12_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("MATCHBOX_PKCS12_PASSWORD", nil),
 			},
 			"ca": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca_file"},
+			},
+			"ca_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca"},
 			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"matchbox_profile": resourceProfile(),
 			"matchbox_group":   resourceGroup(),
 		},
-		ConfigureFunc: providerConfigure,
+		DataSourcesMap: map[string]*schema.Resource{
+			"matchbox_rendered_config": dataSourceRenderedConfig(),
+		},
+		ConfigureContextFunc: providerConfigure,
 	}
 }
 
-func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	ca := d.Get("ca").(string)
-	clientCert := d.Get("client_cert").(string)
-	clientKey := d.Get("client_key").(string)
-	endpoint := d.Get("endpoint").(string)
+func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	// matchbox data directory instead of the gRPC API
+	if dataPath, ok := d.GetOk("data_path"); ok {
+		if d.Get("replicate").(bool) {
+			return nil, diag.Errorf("replicate requires endpoints, not data_path")
+		}
+		info, err := os.Stat(dataPath.(string))
+		if err != nil {
+			return nil, diag.Errorf("invalid data_path: %v", err)
+		}
+		if !info.IsDir() {
+			return nil, diag.Errorf("invalid data_path: %s is not a directory", dataPath)
+		}
+		return Replicas{NewDataPathClient(dataPath.(string))}, nil
+	}
+
+	// endpoint or endpoints to failover between
+	var endpoints []string
+	if endpoint, ok := d.GetOk("endpoint"); ok {
+		endpoints = append(endpoints, endpoint.(string))
+	}
+	for _, endpoint := range d.Get("endpoints").([]interface{}) {
+		endpoints = append(endpoints, endpoint.(string))
+	}
+
+	// named context from the matchbox client config file
+	name := d.Get("context").(string)
+	if name == "" && len(endpoints) == 0 {
+		name = os.Getenv("MATCHBOX_CONTEXT")
+	}
+	clientCtx := &clientContext{}
+	if name != "" {
+		var err error
+		clientCtx, err = loadContext(d.Get("config_path").(string), name)
+		if err != nil {
+			return nil, diag.Errorf("invalid context: %v", err)
+		}
+		endpoints = clientCtx.endpoints()
+	}
+
+	if endpoint := os.Getenv("MATCHBOX_ENDPOINT"); len(endpoints) == 0 && endpoint != "" {
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return nil, diag.Errorf("one of endpoint, endpoints, context, or data_path must be set")
+	}
+
+	ca, clientCert, clientKey, err := readCredentials(d, clientCtx)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	var pins []string
+	for _, pin := range d.Get("server_pins").([]interface{}) {
+		hash, err := parsePin(pin.(string))
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		pins = append(pins, hash)
+	}
+	if (len(ca) == 0 && len(pins) == 0) || len(clientCert) == 0 || len(clientKey) == 0 {
+		return nil, diag.Errorf("client_cert and client_key (or pkcs12_file), and ca or server_pins, are required to use the Matchbox API")
+	}
+
+	// warn before certificates expire, rather than when an apply fails
+	window, _ := time.ParseDuration(d.Get("cert_expiry_warning").(string))
+	diags := certExpiryDiagnostics("client", clientCert, window)
+	diags = append(diags, certExpiryDiagnostics("CA", ca, window)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	crl, err := readPEM(d, "crl", "")
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	var cipherSuites []uint16
+	for _, name := range d.Get("tls_cipher_suites").([]interface{}) {
+		cipherSuites = append(cipherSuites, cipherSuiteIDs[name.(string)])
+	}
+
+	retry, err := retryPolicy(d)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	dialTimeout, _ := time.ParseDuration(d.Get("dial_timeout").(string))
 
 	config := &Config{
-		Endpoint:   endpoint,
-		ClientCert: []byte(clientCert),
-		ClientKey:  []byte(clientKey),
-		CA:         []byte(ca),
+		Endpoints:    endpoints,
+		ProxyURL:     d.Get("proxy_url").(string),
+		ClientCert:   clientCert,
+		ClientKey:    clientKey,
+		CA:           ca,
+		ServerName:   d.Get("tls_server_name").(string),
+		ServerPins:   pins,
+		MinVersion:   tlsVersions[d.Get("tls_min_version").(string)],
+		CipherSuites: cipherSuites,
+		CRL:          crl,
+		DialTimeout:  dialTimeout,
+		Retry:        retry,
+	}
+
+	// write to every endpoint
+	if d.Get("replicate").(bool) {
+		replicas, err := NewMatchboxReplicas(config)
+		if err != nil {
+			return nil, append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  connectionSummary("Failed to create Matchbox replica clients", err),
+				Detail:   fmt.Sprintf("Replicate to endpoints %s:\n%v", strings.Join(endpoints, ", "), err),
+			})
+		}
+		return replicas, diags
 	}
 
 	client, err := NewMatchboxClient(config)
 	if err != nil {
-		return client, fmt.Errorf("failed to create Matchbox client or connect to %s: %v", endpoint, err)
+		return nil, append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  connectionSummary("Failed to create Matchbox client", err),
+			Detail:   fmt.Sprintf("Tried endpoints %s:\n%v", strings.Join(endpoints, ", "), err),
+		})
+	}
+	return Replicas{client}, diags
+}
+
+// readCredentials returns the PEM encoded CA, client certificate, and client
+// key from a PKCS#12 bundle or the separate attributes. Encrypted client keys
+// are decrypted.
+func readCredentials(d *schema.ResourceData, clientCtx *clientContext) (ca, clientCert, clientKey []byte, err error) {
+	ca, err = readPEM(d, "ca", clientCtx.CA)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bundlePath := d.Get("pkcs12_file").(string)
+	if bundlePath == "" && !hasClientCredentials(d, clientCtx) {
+		bundlePath = os.Getenv("MATCHBOX_PKCS12_FILE")
+	}
+	if bundlePath != "" {
+		bundle, err := readFile("pkcs12_file", bundlePath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		clientCert, clientKey, bundleCA, err := decodePKCS12(bundle, d.Get("pkcs12_password").(string))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// ca or ca_file take precedence over the bundle's CA certificates
+		if len(ca) == 0 {
+			ca = bundleCA
+		}
+		return ca, clientCert, clientKey, nil
+	}
+
+	clientCert, err = readPEM(d, "client_cert", clientCtx.ClientCert)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientKey, err = readPEM(d, "client_key", clientCtx.ClientKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientKey, err = decryptKey(clientKey, []byte(d.Get("client_key_passphrase").(string)))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ca, clientCert, clientKey, nil
+}
+
+// hasClientCredentials returns true if a client certificate or key is
+// configured by attribute or context.
+func hasClientCredentials(d *schema.ResourceData, clientCtx *clientContext) bool {
+	for _, key := range []string{"client_cert", "client_cert_file", "client_key", "client_key_file"} {
+		if d.Get(key).(string) != "" {
+			return true
+		}
+	}
+	return clientCtx.ClientCert != "" || clientCtx.ClientKey != ""
+}
+
+// readPEM returns the PEM contents of an inline attribute (e.g. ca) or of the
+// file named by its _file form (e.g. ca_file). If neither is configured, the
+// context's path is used, then the MATCHBOX_CA or MATCHBOX_CA_FILE style
+// environment variables.
+func readPEM(d *schema.ResourceData, key, contextPath string) ([]byte, error) {
+	fileKey := key + "_file"
+	content := d.Get(key).(string)
+	path := d.Get(fileKey).(string)
+
+	if content == "" && path == "" {
+		path = contextPath
+	}
+	if content == "" && path == "" {
+		content = os.Getenv(envName(key))
+		path = os.Getenv(envName(fileKey))
+		if content != "" && path != "" {
+			return nil, fmt.Errorf("%s and %s are mutually exclusive, but both are set", envName(key), envName(fileKey))
+		}
+	}
+
+	if path == "" {
+		return []byte(content), nil
+	}
+	return readFile(fileKey, path)
+}
+
+// readFile reads the file named by a path attribute.
+func readFile(key, path string) ([]byte, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return b, nil
+}
+
+// envName returns the environment variable for an attribute (e.g.
+// client_cert_file is MATCHBOX_CLIENT_CERT_FILE).
+func envName(key string) string {
+	return "MATCHBOX_" + strings.ToUpper(key)
+}
+
+// expandHome expands a leading ~ in a path to the user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// connectionSummary appends the problem to a diagnostic summary if every
+// endpoint failed with the same connection problem.
+func connectionSummary(summary string, err error) string {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	var problem string
+	for _, err := range errs {
+		var connErr *connectionError
+		if !errors.As(err, &connErr) || (problem != "" && connErr.Problem != problem) {
+			return summary
+		}
+		problem = connErr.Problem
+	}
+	return fmt.Sprintf("%s: %s", summary, problem)
+}
+
+// defaultRetryCodes are the gRPC status codes retried if retry_codes isn't
+// set.
+var defaultRetryCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded}
+
+// retryPolicy returns the RetryPolicy for resource RPCs.
+func retryPolicy(d *schema.ResourceData) (RetryPolicy, error) {
+	backoff, err := time.ParseDuration(d.Get("retry_backoff").(string))
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	maxBackoff, err := time.ParseDuration(d.Get("retry_max_backoff").(string))
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	policy := RetryPolicy{
+		MaxAttempts: d.Get("retry_max_attempts").(int),
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+		Codes:       defaultRetryCodes,
+	}
+	if names := d.Get("retry_codes").([]interface{}); len(names) > 0 {
+		policy.Codes = nil
+		for _, name := range names {
+			code, err := parseCode(name.(string))
+			if err != nil {
+				return RetryPolicy{}, err
+			}
+			policy.Codes = append(policy.Codes, code)
+		}
+	}
+	return policy, nil
+}
+
+// tlsVersions maps tls_min_version values to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteIDs maps the names of secure TLS 1.2 cipher suites to their IDs.
+var cipherSuiteIDs = func() map[string]uint16 {
+	ids := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		for _, version := range suite.SupportedVersions {
+			if version == tls.VersionTLS12 {
+				ids[suite.Name] = suite.ID
+			}
+		}
+	}
+	return ids
+}()
+
+// validateCipherSuite validates a TLS 1.2 cipher suite name.
+func validateCipherSuite(v interface{}, key string) ([]string, []error) {
+	if _, ok := cipherSuiteIDs[v.(string)]; !ok {
+		return nil, []error{fmt.Errorf("%s: unsupported TLS 1.2 cipher suite %q", key, v)}
+	}
+	return nil, nil
+}
+
+// validateProxy validates a proxy URL.
+func validateProxy(v interface{}, key string) ([]string, []error) {
+	if err := validateProxyURL(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", key, err)}
+	}
+	return nil, nil
+}
+
+// validateCode validates a gRPC status code name (e.g. UNAVAILABLE).
+func validateCode(v interface{}, key string) ([]string, []error) {
+	if _, err := parseCode(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: invalid gRPC status code %q", key, v)}
+	}
+	return nil, nil
+}
+
+// validatePin validates a server certificate pin (e.g. sha256/BASE64).
+func validatePin(v interface{}, key string) ([]string, []error) {
+	if _, err := parsePin(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", key, err)}
+	}
+	return nil, nil
+}
+
+// validateDuration validates a non-negative Go duration string (e.g. 720h).
+func validateDuration(v interface{}, key string) ([]string, []error) {
+	duration, err := time.ParseDuration(v.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", key, err)}
+	}
+	if duration < 0 {
+		return nil, []error{fmt.Errorf("%s: must not be negative, got %s", key, v)}
+	}
+	return nil, nil
+}
+
+// validatePositiveDuration validates a Go duration string greater than zero.
+func validatePositiveDuration(v interface{}, key string) ([]string, []error) {
+	duration, err := time.ParseDuration(v.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", key, err)}
+	}
+	if duration <= 0 {
+		return nil, []error{fmt.Errorf("%s: must be greater than zero, got %s", key, v)}
 	}
-	return client, err
+	return nil, nil
 }
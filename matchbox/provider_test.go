@@ -1,9 +1,16 @@
 package matchbox
 
 import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
 )
 
 var testProviderFactories = map[string]func() (*schema.Provider, error){
@@ -17,3 +24,311 @@ func TestProvider(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 }
+
+// TestProvider_endpoints checks the provider fails over to the first
+// reachable endpoint and lists each endpoint tried when none are reachable.
+func TestProvider_endpoints(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	// unreachable endpoint
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve address: %v", err)
+	}
+	unreachable := lis.Addr().String()
+	lis.Close()
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      srv.AddProviderConfigWithEndpoints([]string{unreachable}, groupMinimal),
+				ExpectError: regexp.MustCompile("TCP connection refused(.|\\n)*Tried endpoints " + regexp.QuoteMeta(unreachable)),
+			},
+			{
+				Config: srv.AddProviderConfigWithEndpoints([]string{unreachable, srv.Listener.Addr().String()}, groupMinimal),
+				Check: checkMatchboxGroup(srv, &storagepb.Group{
+					Id:       "minimal",
+					Profile:  "worker",
+					Metadata: []byte(`{}`),
+				}),
+			},
+		},
+	})
+}
+
+// TestProvider_credentialFiles checks the provider reads PEM credentials from
+// the _file forms and rejects setting both the inline and _file forms.
+func TestProvider_credentialFiles(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	files := fmt.Sprintf(`
+		provider "matchbox" {
+			endpoint         = "%s"
+			client_cert_file = "%s"
+			client_key_file  = "%s"
+			ca_file          = "%s"
+		}
+
+		%s
+		`,
+		srv.Listener.Addr().String(),
+		mustAbs(t, "testdata/client.crt"),
+		mustAbs(t, "testdata/client.key"),
+		mustAbs(t, "testdata/ca.crt"),
+		groupMinimal)
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      srv.AddProviderConfigWithAttributes(`ca_file = "testdata/ca.crt"`, groupMinimal),
+				ExpectError: regexp.MustCompile(`"ca_file": conflicts with ca`),
+			},
+			{
+				Config: files,
+				Check: checkMatchboxGroup(srv, &storagepb.Group{
+					Id:       "minimal",
+					Profile:  "worker",
+					Metadata: []byte(`{}`),
+				}),
+			},
+		},
+	})
+}
+
+// TestProvider_environment checks the provider defaults its endpoint and
+// credentials from environment variables.
+func TestProvider_environment(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	t.Setenv("MATCHBOX_ENDPOINT", srv.Listener.Addr().String())
+	t.Setenv("MATCHBOX_CLIENT_CERT", string(fakeClientCert))
+	t.Setenv("MATCHBOX_CLIENT_KEY_FILE", mustAbs(t, "testdata/client.key"))
+	t.Setenv("MATCHBOX_CA_FILE", mustAbs(t, "testdata/ca.crt"))
+	t.Setenv("MATCHBOX_CA", string(fakeCACert))
+
+	config := `
+		provider "matchbox" {}
+
+		` + groupMinimal
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      config,
+				ExpectError: regexp.MustCompile("MATCHBOX_CA and MATCHBOX_CA_FILE are mutually exclusive"),
+			},
+			{
+				PreConfig: func() { os.Unsetenv("MATCHBOX_CA") },
+				Config:    config,
+				Check: checkMatchboxGroup(srv, &storagepb.Group{
+					Id:       "minimal",
+					Profile:  "worker",
+					Metadata: []byte(`{}`),
+				}),
+			},
+		},
+	})
+}
+
+// TestProvider_context checks the provider reads the endpoint and credentials
+// of a named context from a matchbox client config file.
+func TestProvider_context(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "site-a"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ca.crt", "client.crt", "client.key"} {
+		if err := os.WriteFile(filepath.Join(dir, "site-a", name), mustReadFile("testdata/"+name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configPath := filepath.Join(dir, "config")
+	config := fmt.Sprintf(`
+contexts:
+- name: site-a
+  endpoint: %s
+  certificate-authority: site-a/ca.crt
+  client-certificate: site-a/client.crt
+  client-key: site-a/client.key
+`, srv.Listener.Addr().String())
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	withContext := func(context string) string {
+		return fmt.Sprintf(`
+		provider "matchbox" {
+			context     = "%s"
+			config_path = "%s"
+		}
+
+		%s
+		`, context, configPath, groupMinimal)
+	}
+	expected := &storagepb.Group{
+		Id:       "minimal",
+		Profile:  "worker",
+		Metadata: []byte(`{}`),
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      withContext("site-b"),
+				ExpectError: regexp.MustCompile(`no context named "site-b"`),
+			},
+			{
+				Config: withContext("site-a"),
+				Check:  checkMatchboxGroup(srv, expected),
+			},
+		},
+	})
+
+	// select the context with environment variables
+	t.Setenv("MATCHBOX_CONFIG", configPath)
+	t.Setenv("MATCHBOX_CONTEXT", "site-a")
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+				provider "matchbox" {}
+
+				` + groupMinimal,
+				Check: checkMatchboxGroup(srv, expected),
+			},
+		},
+	})
+}
+
+// TestProvider_encryptedCredentials checks the provider accepts passphrase
+// protected client keys and PKCS#12 bundles.
+func TestProvider_encryptedCredentials(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(keyPath, encryptedKey(t, "secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := filepath.Join(dir, "client.p12")
+	if err := os.WriteFile(bundlePath, pkcs12Bundle(t, "secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := func(passphrase string) string {
+		return fmt.Sprintf(`
+		provider "matchbox" {
+			endpoint              = "%s"
+			client_cert_file      = "%s"
+			client_key_file       = "%s"
+			client_key_passphrase = "%s"
+			ca_file               = "%s"
+		}
+
+		%s
+		`, srv.Listener.Addr().String(), mustAbs(t, "testdata/client.crt"), keyPath, passphrase, mustAbs(t, "testdata/ca.crt"), groupMinimal)
+	}
+	bundle := fmt.Sprintf(`
+		provider "matchbox" {
+			endpoint        = "%s"
+			pkcs12_file     = "%s"
+			pkcs12_password = "secret"
+		}
+
+		%s
+		`, srv.Listener.Addr().String(), bundlePath, groupMinimal)
+	expected := &storagepb.Group{
+		Id:       "minimal",
+		Profile:  "worker",
+		Metadata: []byte(`{}`),
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      encrypted(""),
+				ExpectError: regexp.MustCompile("client key is encrypted"),
+			},
+			{
+				Config: encrypted("secret"),
+				Check:  checkMatchboxGroup(srv, expected),
+			},
+			{
+				Config: bundle,
+				Check:  checkMatchboxGroup(srv, expected),
+			},
+		},
+	})
+}
+
+func mustAbs(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatalf("absolute path: %v", err)
+	}
+	return abs
+}
+
+func TestValidateDuration(t *testing.T) {
+	cases := []struct {
+		value    string
+		valid    bool
+		positive bool
+	}{
+		{"1s", true, true},
+		{"0s", true, false},
+		{"-1s", false, false},
+		{"soon", false, false},
+	}
+	for _, c := range cases {
+		if _, errs := validateDuration(c.value, "retry_max_backoff"); (len(errs) == 0) != c.valid {
+			t.Errorf("validateDuration(%q): expected valid %t, got %v", c.value, c.valid, errs)
+		}
+		if _, errs := validatePositiveDuration(c.value, "retry_backoff"); (len(errs) == 0) != c.positive {
+			t.Errorf("validatePositiveDuration(%q): expected valid %t, got %v", c.value, c.positive, errs)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package matchbox
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// unixPrefix prefixes endpoints which are Unix sockets (e.g. unix:///path).
+const unixPrefix = "unix://"
+
+// proxyError is a failure to connect to an endpoint through a proxy.
+type proxyError struct {
+	Proxy string
+	Err   error
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("proxy %s: %v", e.Proxy, e.Err)
+}
+
+func (e *proxyError) Unwrap() error {
+	return e.Err
+}
+
+// parseEndpoint returns the network and address to dial for an endpoint and
+// the host to verify the server certificate against. Unix socket endpoints
+// verify against localhost, like gRPC.
+func parseEndpoint(endpoint string) (network, address, host string, err error) {
+	if strings.HasPrefix(endpoint, unixPrefix) {
+		path := strings.TrimPrefix(endpoint, unixPrefix)
+		if path == "" {
+			return "", "", "", fmt.Errorf("missing Unix socket path in %q", endpoint)
+		}
+		return "unix", path, "localhost", nil
+	}
+	host, _, err = net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", "", "", err
+	}
+	return "tcp", endpoint, host, nil
+}
+
+// validateProxyURL validates a proxy URL has a supported scheme.
+func validateProxyURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	}
+	return fmt.Errorf("unsupported proxy scheme %q, expected http, https, socks5, or socks5h", u.Scheme)
+}
+
+// proxyURL returns the proxy to reach a TCP endpoint through, if any. A
+// configured proxy is always used. Otherwise, HTTPS_PROXY or ALL_PROXY are
+// used unless NO_PROXY excludes the endpoint.
+func proxyURL(configured, endpoint string) (*url.URL, error) {
+	if configured != "" {
+		return url.Parse(configured)
+	}
+	env := httpproxy.FromEnvironment()
+	if env.HTTPSProxy == "" {
+		env.HTTPSProxy = getenv("ALL_PROXY", "all_proxy")
+	}
+	return env.ProxyFunc()(&url.URL{Scheme: "https", Host: endpoint})
+}
+
+// dialContext returns a gRPC dialer which connects to TCP endpoints directly
+// or through a proxy and to Unix socket endpoints.
+func dialContext(configuredProxy string) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, endpoint string) (net.Conn, error) {
+		network, address, _, err := parseEndpoint(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{}
+		if network == "unix" {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		u, err := proxyURL(configuredProxy, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		var conn net.Conn
+		switch u.Scheme {
+		case "http", "https":
+			conn, err = dialConnect(ctx, u, address)
+		case "socks5", "socks5h":
+			conn, err = dialSOCKS(ctx, u, address)
+		default:
+			err = validateProxyURL(u.String())
+		}
+		if err != nil {
+			return nil, &proxyError{Proxy: u.Redacted(), Err: err}
+		}
+		return conn, nil
+	}
+}
+
+// dialConnect connects to the address through an HTTP CONNECT proxy.
+func dialConnect(ctx context.Context, u *url.URL, address string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	proxyAddress := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddress = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: u.Hostname(),
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	// bound the CONNECT exchange by the dial deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: %s", address, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		// servers shouldn't send before the client's TLS handshake
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: unexpected data after response", address)
+	}
+	return conn, nil
+}
+
+// dialSOCKS connects to the address through a SOCKS5 proxy.
+func dialSOCKS(ctx context.Context, u *url.URL, address string) (net.Conn, error) {
+	dialer, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
+}
+
+// getenv returns the value of the first environment variable which is set.
+func getenv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
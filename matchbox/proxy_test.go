@@ -0,0 +1,235 @@
+package matchbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestDial_proxy checks endpoints can be reached through HTTP CONNECT and
+// SOCKS5 proxies and Unix sockets.
+func TestDial_proxy(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	// fixture server listening on a Unix socket
+	socket := filepath.Join(t.TempDir(), "matchbox.sock")
+	unixSrv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	unixSrv.Listener.Close()
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	unixSrv.Listener = lis
+	go func() {
+		err := unixSrv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer unixSrv.Stop()
+
+	connectProxy := httptest.NewServer(connectHandler("user", "pass"))
+	defer connectProxy.Close()
+	socksProxy := newSOCKSProxy(t)
+	defer socksProxy.Close()
+
+	endpoint := srv.Listener.Addr().String()
+	cases := []struct {
+		name       string
+		endpoint   string
+		proxy      string
+		serverName string
+		problem    string
+	}{
+		{"connect", endpoint, "http://user:pass@" + connectProxy.Listener.Addr().String(), "", ""},
+		{"connect unauthorized", endpoint, "http://user:wrong@" + connectProxy.Listener.Addr().String(), "", problemProxy},
+		{"socks5", endpoint, "socks5://" + socksProxy.Addr().String(), "", ""},
+		{"unix", "unix://" + socket, "", "matchbox.example.com", ""},
+		{"unix unverified", "unix://" + socket, "", "", problemServerCert},
+	}
+	for _, c := range cases {
+		tlscfg, err := tlsConfig(&Config{
+			CA:         fakeCACert,
+			ClientCert: fakeClientCert,
+			ClientKey:  fakeClientKey,
+			ServerName: c.serverName,
+		})
+		if err != nil {
+			t.Fatalf("%s: tlsConfig: %v", c.name, err)
+		}
+		client, err := dial(c.endpoint, tlscfg, &Config{ProxyURL: c.proxy, DialTimeout: 5 * time.Second})
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+				continue
+			}
+			// RPCs use the proxy too
+			if err := probe(t.Context(), client, tlscfg); err != nil {
+				t.Errorf("%s: expected RPC to succeed, got %v", c.name, err)
+			}
+			client.Close()
+			continue
+		}
+
+		var connErr *connectionError
+		if !errors.As(err, &connErr) || connErr.Problem != c.problem {
+			t.Errorf("%s: expected problem %q, got %v", c.name, c.problem, err)
+		}
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+	t.Setenv("ALL_PROXY", "socks5://all.example.com:1080")
+	t.Setenv("NO_PROXY", "direct.example.com")
+
+	cases := []struct {
+		configured string
+		endpoint   string
+		expected   string
+	}{
+		{"http://proxy.example.com:3128", "direct.example.com:8081", "http://proxy.example.com:3128"},
+		{"", "matchbox.example.com:8081", "socks5://all.example.com:1080"},
+		{"", "direct.example.com:8081", ""},
+	}
+	for _, c := range cases {
+		u, err := proxyURL(c.configured, c.endpoint)
+		if err != nil {
+			t.Errorf("proxyURL(%q, %q): %v", c.configured, c.endpoint, err)
+			continue
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != c.expected {
+			t.Errorf("proxyURL(%q, %q): expected %q, got %q", c.configured, c.endpoint, c.expected, got)
+		}
+	}
+
+	t.Setenv("HTTPS_PROXY", "http://https.example.com:3128")
+	u, err := proxyURL("", "matchbox.example.com:8081")
+	if err != nil || u.String() != "http://https.example.com:3128" {
+		t.Errorf("expected HTTPS_PROXY to take precedence over ALL_PROXY, got %v, %v", u, err)
+	}
+}
+
+// connectHandler is an HTTP CONNECT proxy which requires basic auth.
+func connectHandler(username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "expected CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Header.Set("Authorization", r.Header.Get("Proxy-Authorization"))
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(conn, upstream)
+	})
+}
+
+// newSOCKSProxy returns a listener serving an unauthenticated SOCKS5 proxy
+// which supports CONNECT to IPv4 addresses and domain names.
+func newSOCKSProxy(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS(conn)
+		}
+	}()
+	return lis
+}
+
+func serveSOCKS(conn net.Conn) {
+	// greeting: version, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		conn.Close()
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		conn.Close()
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, upstream)
+}
+
+// pipe copies between connections until either closes.
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
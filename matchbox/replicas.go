@@ -0,0 +1,62 @@
+package matchbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Replicas are the matchbox Clients resources are written to. Matchbox stores
+// data locally on each server, so replicated servers must each be written.
+// Without replication, Replicas holds the single Client chosen by failover.
+type Replicas []*Client
+
+// Each calls fn with each replica's Client. A failure on one replica doesn't
+// prevent writes to the others. When some replicas fail and others succeed,
+// an additional diagnostic names the replicas which are out of sync.
+func (r Replicas) Each(fn func(client *Client) diag.Diagnostics) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var synced, failed []string
+	for _, client := range r {
+		clientDiags := fn(client)
+		diags = append(diags, clientDiags...)
+		if clientDiags.HasError() {
+			failed = append(failed, client.Endpoint)
+		} else {
+			synced = append(synced, client.Endpoint)
+		}
+	}
+
+	if len(synced) > 0 && len(failed) > 0 {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  "Matchbox replicas out of sync",
+			Detail: fmt.Sprintf("Changes were applied to %s, but failed on %s. Apply again to sync replicas.",
+				strings.Join(synced, ", "), strings.Join(failed, ", ")),
+		})
+	}
+	return diags
+}
+
+// outOfSyncDiagnostic returns a warning naming the replicas on which an object
+// is missing or differs.
+func outOfSyncDiagnostic(kind, name, problem string, endpoints []string) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  "Matchbox replicas out of sync",
+		Detail: fmt.Sprintf("%s %q %s on %s. Apply to sync replicas.",
+			kind, name, problem, strings.Join(endpoints, ", ")),
+	}
+}
+
+// resyncReplicas is a CustomizeDiff function which plans an update to sync
+// replicas when Read found a replica missing or differing from the others.
+func resyncReplicas(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() != "" && !d.Get("replicas_in_sync").(bool) {
+		return d.SetNew("replicas_in_sync", true)
+	}
+	return nil
+}
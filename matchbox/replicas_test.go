@@ -0,0 +1,141 @@
+package matchbox
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
+)
+
+// TestReplicas checks resources are written to every replica, drift on any
+// replica is detected, and partial failures name the out of sync replicas.
+func TestReplicas(t *testing.T) {
+	srvA := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	storeB := &BreakableStore{Store: NewFixedStore()}
+	srvB := NewFixtureServer(clientTLSInfo, serverTLSInfo, storeB)
+	for _, srv := range []*FixtureServer{srvA, srvB} {
+		go func(srv *FixtureServer) {
+			err := srv.Start()
+			if err != nil {
+				t.Errorf("fixture server start: %v", err)
+			}
+		}(srv)
+		defer srv.Stop()
+	}
+
+	addrA := srvA.Listener.Addr().String()
+	addrB := srvB.Listener.Addr().String()
+	attrs := fmt.Sprintf(`
+			endpoints = ["%s", "%s"]
+			replicate = true`, addrA, addrB)
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
+		}
+
+		resource "matchbox_group" "default" {
+			name    = "default"
+			profile = matchbox_profile.default.name
+			selector = {
+				os = "installed"
+			}
+		}
+	`
+
+	updated := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
+		}
+
+		resource "matchbox_group" "default" {
+			name    = "default"
+			profile = "altered"
+			selector = {
+				os = "installed"
+			}
+		}
+	`
+
+	checkReplicas := func(profile string) resource.TestCheckFunc {
+		return func(s *terraform.State) error {
+			for _, srv := range []*FixtureServer{srvA, srvB} {
+				if _, err := srv.Store.ProfileGet("default"); err != nil {
+					return fmt.Errorf("%s: %v", srv.Listener.Addr(), err)
+				}
+				if _, err := srv.Store.IgnitionGet("default.ign"); err != nil {
+					return fmt.Errorf("%s: %v", srv.Listener.Addr(), err)
+				}
+			}
+			expected := &storagepb.Group{
+				Id:       "default",
+				Profile:  profile,
+				Selector: map[string]string{"os": "installed"},
+				Metadata: []byte(`{}`),
+			}
+			return resource.ComposeTestCheckFunc(
+				checkMatchboxGroup(srvA, expected),
+				checkMatchboxGroup(srvB, expected),
+			)(s)
+		}
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srvA.AddProviderConfigWithAttributes(attrs, hcl),
+				Check:  checkReplicas("default"),
+			},
+			// drift on the second replica
+			{
+				PreConfig: func() {
+					group, _ := storeB.GroupGet("default")
+					group.Profile = "altered"
+				},
+				Config:             srvA.AddProviderConfigWithAttributes(attrs, hcl),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: srvA.AddProviderConfigWithAttributes(attrs, hcl),
+				Check:  checkReplicas("default"),
+			},
+			// missing on the second replica
+			{
+				PreConfig: func() {
+					storeB.Store.ProfileDelete("default")
+				},
+				Config:             srvA.AddProviderConfigWithAttributes(attrs, hcl),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: srvA.AddProviderConfigWithAttributes(attrs, hcl),
+				Check:  checkReplicas("default"),
+			},
+			// partial failure
+			{
+				PreConfig: func() {
+					storeB.BrokenWrites = true
+				},
+				Config:      srvA.AddProviderConfigWithAttributes(attrs, updated),
+				ExpectError: regexp.MustCompile(fmt.Sprintf(`Changes were applied to %s, but failed on\s+%s`, addrA, addrB)),
+			},
+			{
+				PreConfig: func() {
+					storeB.BrokenWrites = false
+				},
+				Config: srvA.AddProviderConfigWithAttributes(attrs, updated),
+				Check:  checkReplicas("altered"),
+			},
+		},
+	})
+}
@@ -3,10 +3,14 @@ package matchbox
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	matchbox "github.com/poseidon/matchbox/matchbox/client"
 
 	"github.com/poseidon/matchbox/matchbox/server/serverpb"
 	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
@@ -16,7 +20,13 @@ func resourceGroup() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceGroupCreate,
 		ReadContext:   resourceGroupRead,
+		UpdateContext: resourceGroupUpdate,
 		DeleteContext: resourceGroupDelete,
+		CustomizeDiff: customdiff.All(validateGroupKeys, resyncReplicas),
+		Timeouts:      resourceTimeouts(),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGroupImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -27,104 +37,276 @@ func resourceGroup() *schema.Resource {
 			"profile": {
 				Type:     schema.TypeString,
 				Required: true,
-				ForceNew: true,
 			},
 			"selector": {
 				Type:     schema.TypeMap,
 				Optional: true,
 				Elem:     schema.TypeString,
-				ForceNew: true,
 			},
 			"metadata": {
 				Type:     schema.TypeMap,
 				Optional: true,
 				Elem:     schema.TypeString,
-				ForceNew: true,
+			},
+			"required_metadata_keys": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Optional: true,
+			},
+			"replicas_in_sync": {
+				Type:     schema.TypeBool,
+				Computed: true,
 			},
 		},
 	}
 }
 
+// resourceGroupCreate creates a Group on each replica.
 func resourceGroupCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
-
-	client := meta.(*matchbox.Client)
-	name := d.Get("name").(string)
+	replicas := meta.(Replicas)
 
-	selectors := map[string]string{}
-	for k, v := range d.Get("selector").(map[string]interface{}) {
-		selectors[k] = v.(string)
+	group, err := groupFromResourceData(d)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
-	richGroup := &storagepb.RichGroup{
-		Id:       name,
-		Profile:  d.Get("profile").(string),
-		Selector: selectors,
-		Metadata: d.Get("metadata").(map[string]interface{}),
+	diags := replicas.Each(func(client *Client) diag.Diagnostics {
+		diags := putGroup(ctx, client, group)
+		if !diags.HasError() {
+			d.SetId(group.GetId())
+		}
+		return diags
+	})
+	if err := d.Set("replicas_in_sync", !diags.HasError()); err != nil {
+		return append(diags, diag.FromErr(err)...)
 	}
-	group, err := richGroup.ToGroup()
+	return diags
+}
+
+// resourceGroupUpdate replaces a Group's profile, selector, and metadata with
+// a single put on each replica, so machines are never matched without the
+// Group. Puts also sync replicas which were missing or differed.
+func resourceGroupUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	replicas := meta.(Replicas)
+
+	group, err := groupFromResourceData(d)
 	if err != nil {
 		return diag.FromErr(err)
 	}
 
-	_, err = client.Groups.GroupPut(ctx, &serverpb.GroupPutRequest{
+	// keep prior state if the update fails on a replica
+	d.Partial(true)
+
+	diags := replicas.Each(func(client *Client) diag.Diagnostics {
+		return putGroup(ctx, client, group)
+	})
+	if !diags.HasError() {
+		d.Partial(false)
+	}
+	return diags
+}
+
+func putGroup(ctx context.Context, client *Client, group *storagepb.Group) diag.Diagnostics {
+	var diags diag.Diagnostics
+	_, err := client.Groups.GroupPut(ctx, &serverpb.GroupPutRequest{
 		Group: group,
 	})
 	if err != nil {
-		return diag.FromErr(err)
+		return rpcDiagnostics(client, "GroupPut", group.GetId(), err)
 	}
-
-	d.SetId(group.GetId())
 	return diags
 }
 
+// resourceGroupRead reads a Group from each replica. The Group needs creating
+// if every replica is missing it and needs updating if any replica is missing
+// it or differs from the first.
 func resourceGroupRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
-	client := meta.(*matchbox.Client)
+	replicas := meta.(Replicas)
 
 	name := d.Get("name").(string)
-	groupGetResponse, err := client.Groups.GroupGet(ctx, &serverpb.GroupGetRequest{
-		Id: name,
-	})
+	var current *groupState
+	var first string
+	var missing, outOfSync []string
+	for _, client := range replicas {
+		state, readDiags := readGroup(ctx, client, name)
+		if readDiags.HasError() {
+			return readDiags
+		}
+		switch {
+		case state == nil:
+			missing = append(missing, client.Endpoint)
+		case current == nil:
+			current, first = state, client.Endpoint
+		case !reflect.DeepEqual(state, current):
+			outOfSync = append(outOfSync, client.Endpoint)
+		}
+	}
 
-	if err != nil {
+	if current == nil {
 		// resource doesn't exist anymore
 		d.SetId("")
-		return nil
+		return diags
 	}
 
-	group := groupGetResponse.Group
-
-	if err := d.Set("selector", group.Selector); err != nil {
+	if len(missing) > 0 {
+		diags = append(diags, outOfSyncDiagnostic("Group", name, "is missing", missing))
+	}
+	if len(outOfSync) > 0 {
+		diags = append(diags, outOfSyncDiagnostic("Group", name, "differs from "+first, outOfSync))
+	}
+	if err := d.Set("replicas_in_sync", len(missing)+len(outOfSync) == 0); err != nil {
 		return diag.FromErr(err)
 	}
-	if err := d.Set("profile", group.Profile); err != nil {
+	if err := current.set(d); err != nil {
 		return diag.FromErr(err)
 	}
+	return diags
+}
 
-	var metadata map[string]string
+// readGroup reads a Group. If the Group doesn't exist, nil is returned.
+func readGroup(ctx context.Context, client *Client, name string) (*groupState, diag.Diagnostics) {
+	groupGetResponse, err := client.Groups.GroupGet(ctx, &serverpb.GroupGetRequest{
+		Id: name,
+	})
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, rpcDiagnostics(client, "GroupGet", name, err)
+	}
+
+	group := groupGetResponse.Group
+	state := &groupState{
+		Profile: group.Profile,
+	}
+	if len(group.Selector) > 0 {
+		state.Selector = group.Selector
+	}
 	if len(group.Metadata) > 0 {
-		if err := json.Unmarshal(group.Metadata, &metadata); err != nil {
-			return diag.FromErr(err)
+		if err := json.Unmarshal(group.Metadata, &state.Metadata); err != nil {
+			return nil, diag.FromErr(err)
+		}
+		if len(state.Metadata) == 0 {
+			state.Metadata = nil
 		}
 	}
-	if err := d.Set("metadata", metadata); err != nil {
-		return diag.FromErr(err)
+	return state, nil
+}
+
+// resourceGroupImport imports a Group by id. Read fetches the Group's profile,
+// selector, and metadata. Groups with metadata the resource can't manage are
+// rejected.
+func resourceGroupImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	replicas := meta.(Replicas)
+
+	for _, client := range replicas {
+		groupGetResponse, err := client.Groups.GroupGet(ctx, &serverpb.GroupGetRequest{
+			Id: d.Id(),
+		})
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("GroupGet %q on %s: %v", d.Id(), client.Endpoint, err)
+		}
+		if err := checkGroupMetadata(groupGetResponse.Group); err != nil {
+			return nil, fmt.Errorf("%s: %v", client.Endpoint, err)
+		}
 	}
-	return diags
+
+	if err := d.Set("name", d.Id()); err != nil {
+		return nil, err
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// checkGroupMetadata checks a Group's metadata values are strings, since
+// metadata is a map of strings.
+func checkGroupMetadata(group *storagepb.Group) error {
+	if len(group.GetMetadata()) == 0 {
+		return nil
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(group.GetMetadata(), &metadata); err != nil {
+		return fmt.Errorf("group %q metadata isn't a JSON object: %v", group.GetId(), err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		var kind string
+		switch metadata[key].(type) {
+		case string:
+			continue
+		case []interface{}:
+			kind = "a list"
+		case map[string]interface{}:
+			kind = "an object"
+		case bool:
+			kind = "a boolean"
+		case float64:
+			kind = "a number"
+		default:
+			kind = "null"
+		}
+		return fmt.Errorf("group %q metadata %q is %s, but only groups with string metadata values can be imported, since matchbox_group metadata is a map of strings", group.GetId(), key, kind)
+	}
+	return nil
 }
 
+// resourceGroupDelete deletes a Group from each replica. Deleting a Group
+// which no longer exists is a no-op.
 func resourceGroupDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
-	client := meta.(*matchbox.Client)
+	replicas := meta.(Replicas)
 
 	name := d.Get("name").(string)
-	_, err := client.Groups.GroupDelete(ctx, &serverpb.GroupDeleteRequest{
-		Id: name,
+	diags := replicas.Each(func(client *Client) diag.Diagnostics {
+		var diags diag.Diagnostics
+		_, err := client.Groups.GroupDelete(ctx, &serverpb.GroupDeleteRequest{
+			Id: name,
+		})
+		if err != nil && !isNotFound(err) {
+			return rpcDiagnostics(client, "GroupDelete", name, err)
+		}
+		return diags
 	})
-	if err != nil {
-		return diag.FromErr(err)
+	if diags.HasError() {
+		return diags
 	}
+
 	d.SetId("")
 	return diags
 }
+
+// groupFromResourceData returns the matchbox Group described by the resource.
+func groupFromResourceData(d *schema.ResourceData) (*storagepb.Group, error) {
+	selectors := map[string]string{}
+	for k, v := range d.Get("selector").(map[string]interface{}) {
+		selectors[k] = v.(string)
+	}
+
+	richGroup := &storagepb.RichGroup{
+		Id:       d.Get("name").(string),
+		Profile:  d.Get("profile").(string),
+		Selector: selectors,
+		Metadata: d.Get("metadata").(map[string]interface{}),
+	}
+	return richGroup.ToGroup()
+}
+
+// groupState holds a Group's attributes for comparison between replicas.
+// Empty maps are nil.
+type groupState struct {
+	Profile  string
+	Selector map[string]string
+	Metadata map[string]string
+}
+
+// set sets the resource's attributes from the groupState.
+func (s *groupState) set(d *schema.ResourceData) error {
+	if err := d.Set("selector", s.Selector); err != nil {
+		return err
+	}
+	if err := d.Set("profile", s.Profile); err != nil {
+		return err
+	}
+	return d.Set("metadata", s.Metadata)
+}
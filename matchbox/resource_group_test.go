@@ -2,13 +2,14 @@ package matchbox
 
 import (
 	"fmt"
-	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
-	"github.com/poseidon/matchbox/matchbox/storage/testfakes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
 )
 
 const groupWithAllFields = `
@@ -32,7 +33,7 @@ const groupMinimal = `
 `
 
 func TestResourceGroup(t *testing.T) {
-	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, testfakes.NewFixedStore())
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
 	go func() {
 		err := srv.Start()
 		if err != nil {
@@ -82,7 +83,7 @@ func TestResourceGroup(t *testing.T) {
 // TestResourceGroup_Read checks the provider compares the desired state with
 // the actual matchbox state
 func TestResourceGroup_Read(t *testing.T) {
-	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, testfakes.NewFixedStore())
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
 	go func() {
 		err := srv.Start()
 		if err != nil {
@@ -130,16 +131,197 @@ func TestResourceGroup_Read(t *testing.T) {
 	})
 }
 
+// TestResourceGroup_Update checks a Group's profile, selector, and metadata
+// are updated in-place
+func TestResourceGroup_Update(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	updated := `
+		resource "matchbox_group" "default" {
+			name    = "default"
+			profile = "controller"
+			selector = {
+				os  = "installed"
+				mac = "52:54:00:a1:9c:ae"
+			}
+			metadata = {
+				user = "fedora"
+			}
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(groupWithAllFields),
+			},
+			{
+				Config: srv.AddProviderConfig(updated),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("matchbox_group.default", "id", "default"),
+					resource.TestCheckResourceAttr("matchbox_group.default", "profile", "controller"),
+					resource.TestCheckResourceAttr("matchbox_group.default", "selector.%", "2"),
+					resource.TestCheckResourceAttr("matchbox_group.default", "metadata.user", "fedora"),
+					checkMatchboxGroup(srv, &storagepb.Group{
+						Id:       "default",
+						Profile:  "controller",
+						Selector: map[string]string{"os": "installed", "mac": "52:54:00:a1:9c:ae"},
+						Metadata: []byte(`{"user":"fedora"}`),
+					}),
+				),
+			},
+		},
+	})
+}
+
 func checkMatchboxGroup(srv *FixtureServer, expected *storagepb.Group) resource.TestCheckFunc {
 	fn := func(s *terraform.State) error {
 		grp, err := srv.Store.GroupGet(expected.Id)
 		if err != nil {
 			return err
 		}
-		if !reflect.DeepEqual(grp, expected) {
+		if !proto.Equal(protoadapt.MessageV2Of(grp), protoadapt.MessageV2Of(expected)) {
 			return fmt.Errorf("expected %+v, got %+v", expected, grp)
 		}
 		return nil
 	}
 	return fn
 }
+
+// TestResourceGroup_Import checks Groups created outside Terraform can be
+// imported.
+func TestResourceGroup_Import(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	// non-string metadata can't be managed
+	if err := srv.Store.GroupPut(&storagepb.Group{Id: "keys", Metadata: []byte(`{"pxe":true,"ssh_authorized_keys":["a","b"]}`)}); err != nil {
+		t.Fatalf("GroupPut: %v", err)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(groupWithAllFields),
+			},
+			{
+				ResourceName:      "matchbox_group.default",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+			{
+				ResourceName:  "matchbox_group.default",
+				ImportState:   true,
+				ImportStateId: "keys",
+				ExpectError:   regexp.MustCompile(`group "keys" metadata "pxe" is a boolean, but only groups\s+with string metadata values can be imported`),
+			},
+		},
+	})
+}
+
+// TestResourceGroup_RequiredMetadataKeys checks Groups must define the keys
+// their Profile's templates reference, if required.
+func TestResourceGroup_RequiredMetadataKeys(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := func(metadata string) string {
+		return fmt.Sprintf(`
+			resource "matchbox_profile" "worker" {
+				name   = "worker"
+				kernel = "foo"
+				container_linux_config = "hostname: {{.hostname}}\nmac: {{.mac}}"
+			}
+
+			resource "matchbox_group" "default" {
+				name    = "default"
+				profile = matchbox_profile.worker.name
+				selector = {
+					MAC = "52:54:00:a1:9c:ae"
+				}
+				metadata = {
+					%s
+				}
+				required_metadata_keys = matchbox_profile.worker.template_keys
+			}
+		`, metadata)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl(`hostname = "node1"`)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("matchbox_profile.worker", "template_keys.#", "2"),
+					resource.TestCheckResourceAttr("matchbox_profile.worker", "template_keys.0", "hostname"),
+					resource.TestCheckResourceAttr("matchbox_profile.worker", "template_keys.1", "mac"),
+				),
+			},
+			{
+				Config:      srv.AddProviderConfig(hcl(`host_name = "node1"`)),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`missing required_metadata_keys hostname`),
+			},
+		},
+	})
+}
+
+// TestResourceGroup_ReadError checks Groups are only removed from state when
+// matchbox reports them missing, not when reads fail.
+func TestResourceGroup_ReadError(t *testing.T) {
+	store := &BreakableStore{Store: NewFixedStore()}
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, store)
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(groupWithAllFields),
+			},
+			{
+				PreConfig: func() {
+					store.BrokenReads = true
+				},
+				Config:      srv.AddProviderConfig(groupWithAllFields),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`GroupGet "default" on 127.0.0.1:\d+`),
+			},
+			{
+				PreConfig: func() {
+					store.BrokenReads = false
+				},
+				Config:   srv.AddProviderConfig(groupWithAllFields),
+				PlanOnly: true,
+			},
+		},
+	})
+}
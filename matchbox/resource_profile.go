@@ -1,24 +1,38 @@
 package matchbox
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"path"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 
-	matchbox "github.com/poseidon/matchbox/matchbox/client"
 	"github.com/poseidon/matchbox/matchbox/server/serverpb"
 	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func resourceProfile() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceProfileCreate,
 		ReadContext:   resourceProfileRead,
+		UpdateContext: resourceProfileUpdate,
 		DeleteContext: resourceProfileDelete,
+		CustomizeDiff: customdiff.All(validateProfileDiff, renderIgnition, planIgnitionChanges, planTemplateKeys, resyncReplicas),
+		Timeouts:      resourceTimeouts(),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceProfileImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -29,201 +43,730 @@ func resourceProfile() *schema.Resource {
 			"kernel": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
 			},
 			"initrd": {
 				Type: schema.TypeList,
 				Elem: &schema.Schema{
-					Type: schema.TypeString,
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringIsNotWhiteSpace,
 				},
-				Optional: true,
-				ForceNew: true,
+				Optional:     true,
+				RequiredWith: []string{"kernel"},
 			},
 			"args": {
 				Type: schema.TypeList,
 				Elem: &schema.Schema{
-					Type: schema.TypeString,
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringIsNotWhiteSpace,
 				},
-				Optional: true,
-				ForceNew: true,
+				Optional:     true,
+				RequiredWith: []string{"kernel"},
 			},
 			"container_linux_config": {
-				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				ConflictsWith:    []string{"raw_ignition", "butane_config", "ignition_fragments"},
+				ValidateDiagFunc: validateTemplate,
 			},
 			"raw_ignition": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ConflictsWith:    []string{"container_linux_config", "butane_config", "ignition_fragments"},
+				ValidateDiagFunc: validateIgnition,
+				DiffSuppressFunc: suppressEquivalentIgnition,
+			},
+			"butane_config": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"container_linux_config", "raw_ignition", "ignition_fragments"},
+			},
+			"butane_strict": {
+				Type:         schema.TypeBool,
+				Optional:     true,
+				RequiredWith: []string{"butane_config"},
+			},
+			"butane_files_dir": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"butane_config"},
+			},
+			"ignition_fragments": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validateIgnition,
+				},
+				Optional:      true,
+				ConflictsWith: []string{"container_linux_config", "raw_ignition", "butane_config"},
+			},
+			"ignition_fragments_strict": {
+				Type:         schema.TypeBool,
+				Optional:     true,
+				RequiredWith: []string{"ignition_fragments"},
+			},
+			"ignition_fragment_conflicts": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+			"rendered_ignition": {
 				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
+				Computed: true,
+			},
+			"ignition_changes": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
 			},
 			"generic_config": {
-				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: validateTemplate,
+			},
+			"template_keys": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+			"replicas_in_sync": {
+				Type:     schema.TypeBool,
+				Computed: true,
 			},
 		},
 	}
 }
 
-// resourceProfileCreate creates a Profile and its associated configs. Partial
-// creates do not modify state and can be retried safely.
+// resourceProfileCreate creates a Profile and its associated configs on each
+// replica. Partial creates are rolled back, do not modify state, and can be
+// retried safely.
 func resourceProfileCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
-	client := meta.(*matchbox.Client)
-
-	if err := validateResourceProfile(d); err != nil {
-		return diag.FromErr(err)
-	}
+	replicas := meta.(Replicas)
 
-	// Profile
-	name := d.Get("name").(string)
-	// NetBoot
-	var initrds []string
-	for _, initrd := range d.Get("initrd").([]interface{}) {
-		initrds = append(initrds, initrd.(string))
+	diags := replicas.Each(func(client *Client) diag.Diagnostics {
+		return createProfile(ctx, d, client)
+	})
+	if err := d.Set("replicas_in_sync", !diags.HasError()); err != nil {
+		return append(diags, diag.FromErr(err)...)
 	}
-	var args []string
-	for _, arg := range d.Get("args").([]interface{}) {
-		args = append(args, arg.(string))
-	}
-	// Container Linux config / Ignition config
-	clcName, _ := containerLinuxConfig(d)
-	// Generic (experimental) config
-	genericName, _ := genericConfig(d)
-
-	profile := &storagepb.Profile{
-		Id: name,
-		Boot: &storagepb.NetBoot{
-			Kernel: d.Get("kernel").(string),
-			Initrd: initrds,
-			Args:   args,
-		},
-		IgnitionId: clcName,
-		GenericId:  genericName,
+	if err := clearRenderedIgnition(d); err != nil {
+		return append(diags, diag.FromErr(err)...)
 	}
+	return diags
+}
 
-	// Profile
-	_, err := client.Profiles.ProfilePut(ctx, &serverpb.ProfilePutRequest{
-		Profile: profile,
-	})
-	if err != nil {
-		return diag.FromErr(err)
-	}
+// createProfile creates a Profile's configs and then the Profile, so booting
+// machines never match a Profile whose configs are missing. If a step fails,
+// configs already written are deleted (or restored, if they existed before).
+func createProfile(ctx context.Context, d *schema.ResourceData, client *Client) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var written profileConfigs
 
 	// Container Linux Config
 	if name, content := containerLinuxConfig(d); content != "" {
-		_, err = client.Ignition.IgnitionPut(ctx, &serverpb.IgnitionPutRequest{
+		ignitionGetResponse, getErr := client.Ignition.IgnitionGet(ctx, &serverpb.IgnitionGetRequest{
+			Name: name,
+		})
+		if getErr != nil && !isNotFound(getErr) {
+			return written.rollback(ctx, client, rpcDiagnostics(client, "IgnitionGet", name, getErr))
+		}
+		_, err := client.Ignition.IgnitionPut(ctx, &serverpb.IgnitionPutRequest{
 			Name:   name,
 			Config: []byte(content),
 		})
 		if err != nil {
-			return diag.FromErr(err)
+			return written.rollback(ctx, client, rpcDiagnostics(client, "IgnitionPut", name, err))
+		}
+		written.ignition = &writtenConfig{
+			name:    name,
+			existed: getErr == nil,
+			prior:   ignitionGetResponse.GetConfig(),
 		}
 	}
 
 	// Generic Config
 	if name, content := genericConfig(d); content != "" {
-		_, err = client.Generic.GenericPut(ctx, &serverpb.GenericPutRequest{
+		genericGetResponse, getErr := client.Generic.GenericGet(ctx, &serverpb.GenericGetRequest{
+			Name: name,
+		})
+		if getErr != nil && !isNotFound(getErr) {
+			return written.rollback(ctx, client, rpcDiagnostics(client, "GenericGet", name, getErr))
+		}
+		_, err := client.Generic.GenericPut(ctx, &serverpb.GenericPutRequest{
 			Name:   name,
 			Config: []byte(content),
 		})
 		if err != nil {
-			return diag.FromErr(err)
+			return written.rollback(ctx, client, rpcDiagnostics(client, "GenericPut", name, err))
+		}
+		written.generic = &writtenConfig{
+			name:    name,
+			existed: getErr == nil,
+			prior:   genericGetResponse.GetConfig(),
+		}
+	}
+
+	// Profile
+	profile := profileFromResourceData(d)
+	_, err := client.Profiles.ProfilePut(ctx, &serverpb.ProfilePutRequest{
+		Profile: profile,
+	})
+	if err != nil {
+		diags = rpcDiagnostics(client, "ProfilePut", profile.GetId(), err)
+		if mayHaveApplied(err) {
+			// rolling back would remove the configs of a created Profile
+			return append(diags, written.keptDiagnostic(client, profile.GetId()))
 		}
+		return written.rollback(ctx, client, diags)
 	}
 
 	d.SetId(profile.GetId())
 	return diags
 }
 
-func validateResourceProfile(d *schema.ResourceData) error {
-	_, hasRAW := d.GetOk("raw_ignition")
-	_, hasCLC := d.GetOk("container_linux_config")
-	if hasCLC && hasRAW {
-		return errors.New("container_linux_config and raw_ignition are mutually exclusive")
+// mayHaveApplied returns true if a failed RPC may still have been applied by
+// the server (e.g. the client stopped waiting for a response).
+func mayHaveApplied(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Unavailable, codes.Canceled:
+		return true
 	}
-	return nil
+	return false
 }
 
-func resourceProfileRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+// rollbackTimeout bounds deleting configs after a failed create, which may
+// have failed because the create's timeout elapsed.
+const rollbackTimeout = 30 * time.Second
+
+// profileConfigs are the Ignition and generic configs written by a create.
+type profileConfigs struct {
+	ignition *writtenConfig
+	generic  *writtenConfig
+}
+
+// writtenConfig is a config written by a create and its prior content, if it
+// already existed.
+type writtenConfig struct {
+	name    string
+	existed bool
+	prior   []byte
+}
+
+// names returns the names of the written configs.
+func (c profileConfigs) names() []string {
+	var names []string
+	if c.ignition != nil {
+		names = append(names, fmt.Sprintf("Ignition config %q", c.ignition.name))
+	}
+	if c.generic != nil {
+		names = append(names, fmt.Sprintf("generic config %q", c.generic.name))
+	}
+	return names
+}
+
+// keptDiagnostic returns an error naming the configs kept after a Profile put
+// which may have been applied.
+func (c profileConfigs) keptDiagnostic(client *Client, name string) diag.Diagnostic {
+	detail := fmt.Sprintf("The Profile %q may have been created on %s, so the configs written for it were kept.", name, client.Endpoint)
+	if names := c.names(); len(names) > 0 {
+		detail += fmt.Sprintf(" If the Profile doesn't exist, remove them by hand or apply again:\n%s", strings.Join(names, "\n"))
+	}
+	return diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  "Matchbox create outcome unknown",
+		Detail:   detail,
+	}
+}
+
+// rollback deletes the configs written by a failed create, or restores their
+// prior content, and returns its diagnostics. Configs which couldn't be
+// rolled back are reported, so they can be fixed by hand.
+func (c profileConfigs) rollback(ctx context.Context, client *Client, diags diag.Diagnostics) diag.Diagnostics {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	var remaining []string
+	if config := c.ignition; config != nil {
+		var err error
+		if config.existed {
+			_, err = client.Ignition.IgnitionPut(ctx, &serverpb.IgnitionPutRequest{
+				Name:   config.name,
+				Config: config.prior,
+			})
+		} else {
+			_, err = client.Ignition.IgnitionDelete(ctx, &serverpb.IgnitionDeleteRequest{
+				Name: config.name,
+			})
+		}
+		if err != nil && !isNotFound(err) {
+			remaining = append(remaining, fmt.Sprintf("Ignition config %q: %v", config.name, err))
+		}
+	}
+	if config := c.generic; config != nil {
+		var err error
+		if config.existed {
+			_, err = client.Generic.GenericPut(ctx, &serverpb.GenericPutRequest{
+				Name:   config.name,
+				Config: config.prior,
+			})
+		} else {
+			_, err = client.Generic.GenericDelete(ctx, &serverpb.GenericDeleteRequest{
+				Name: config.name,
+			})
+		}
+		if err != nil && !isNotFound(err) {
+			remaining = append(remaining, fmt.Sprintf("generic config %q: %v", config.name, err))
+		}
+	}
+
+	if len(remaining) > 0 {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  "Matchbox create rollback incomplete",
+			Detail: fmt.Sprintf("The Profile wasn't created, but configs written on %s couldn't be deleted or restored:\n%s",
+				client.Endpoint, strings.Join(remaining, "\n")),
+		})
+	}
+	return diags
+}
+
+// resourceProfileUpdate updates a Profile and its associated configs in-place
+// on each replica. Ignition changes (and ignition_fragments conflicts) are
+// summarized when planning, as ignition_changes and
+// ignition_fragment_conflicts, since plan diffs of single-line Ignition JSON
+// are hard to read.
+func resourceProfileUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	replicas := meta.(Replicas)
+
+	// keep prior state if the update fails part way
+	d.Partial(true)
+
+	diags := replicas.Each(func(client *Client) diag.Diagnostics {
+		return updateProfile(ctx, d, client)
+	})
+	if diags.HasError() {
+		return diags
+	}
+	d.Partial(false)
+	if err := clearRenderedIgnition(d); err != nil {
+		return append(diags, diag.FromErr(err)...)
+	}
+	return diags
+}
+
+// clearRenderedIgnition sets rendered_ignition empty for Profiles without a
+// butane_config or ignition_fragments. The SDK plans an empty computed value
+// as unknown, which would otherwise keep the prior Ignition in state.
+func clearRenderedIgnition(d *schema.ResourceData) error {
+	if d.Get("butane_config").(string) != "" || len(d.Get("ignition_fragments").([]interface{})) > 0 {
+		return nil
+	}
+	return d.Set("rendered_ignition", "")
+}
+
+// updateProfile updates a Profile and its associated configs in-place.
+// Changed configs are written before the Profile that references them and
+// configs the Profile no longer references are deleted afterwards, so booting
+// machines never match a Profile whose configs are missing. When replicas are
+// out of sync, all configs are written.
+func updateProfile(ctx context.Context, d *schema.ResourceData, client *Client) diag.Diagnostics {
 	var diags diag.Diagnostics
-	client := meta.(*matchbox.Client)
+	resync := d.HasChange("replicas_in_sync")
+
+	// Container Linux Config
+	if resync || d.HasChanges("container_linux_config", "raw_ignition", "rendered_ignition") {
+		if name, content := containerLinuxConfig(d); content != "" {
+			_, err := client.Ignition.IgnitionPut(ctx, &serverpb.IgnitionPutRequest{
+				Name:   name,
+				Config: []byte(content),
+			})
+			if err != nil {
+				return rpcDiagnostics(client, "IgnitionPut", name, err)
+			}
+		}
+	}
+
+	// Generic Config
+	if resync || d.HasChange("generic_config") {
+		if name, content := genericConfig(d); content != "" {
+			_, err := client.Generic.GenericPut(ctx, &serverpb.GenericPutRequest{
+				Name:   name,
+				Config: []byte(content),
+			})
+			if err != nil {
+				return rpcDiagnostics(client, "GenericPut", name, err)
+			}
+		}
+	}
 
 	// Profile
-	name := d.Get("name").(string)
-	profileGetResponse, err := client.Profiles.ProfileGet(ctx, &serverpb.ProfileGetRequest{
-		Id: name,
+	profile := profileFromResourceData(d)
+	_, err := client.Profiles.ProfilePut(ctx, &serverpb.ProfilePutRequest{
+		Profile: profile,
 	})
 	if err != nil {
-		// resource doesn't exist or is corrupted and needs creating
+		return rpcDiagnostics(client, "ProfilePut", profile.GetId(), err)
+	}
+
+	// Stale Container Linux Config (e.g. switched to raw Ignition)
+	if name := priorContainerLinuxConfigName(d); name != "" && name != profile.GetIgnitionId() {
+		_, err = client.Ignition.IgnitionDelete(ctx, &serverpb.IgnitionDeleteRequest{
+			Name: name,
+		})
+		if err != nil && !isNotFound(err) {
+			return rpcDiagnostics(client, "IgnitionDelete", name, err)
+		}
+	}
+
+	// Stale Generic Config
+	if prior, _ := d.GetChange("generic_config"); prior.(string) != "" && profile.GetGenericId() == "" {
+		name := profile.GetId()
+		_, err = client.Generic.GenericDelete(ctx, &serverpb.GenericDeleteRequest{
+			Name: name,
+		})
+		if err != nil && !isNotFound(err) {
+			return rpcDiagnostics(client, "GenericDelete", name, err)
+		}
+	}
+
+	return diags
+}
+
+// validateProfileDiff is a CustomizeDiff function which checks initrd names
+// and initrd= kernel arguments are unique. Unknown list entries are skipped.
+func validateProfileDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	var errs []error
+
+	// initrd entries are named by iPXE --name (or -n) or the URL's basename
+	names := map[string]int{}
+	for i, v := range d.Get("initrd").([]interface{}) {
+		name := initrdName(v.(string))
+		if name == "" {
+			continue
+		}
+		if j, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("initrd[%d]: duplicate initrd name %q, also used by initrd[%d]", i, name, j))
+			continue
+		}
+		names[name] = i
+	}
+
+	args := map[string]int{}
+	for i, v := range d.Get("args").([]interface{}) {
+		for _, field := range strings.Fields(v.(string)) {
+			name, ok := strings.CutPrefix(field, "initrd=")
+			if !ok {
+				continue
+			}
+			if j, ok := args[name]; ok {
+				errs = append(errs, fmt.Errorf("args[%d]: duplicate initrd=%s, also set by args[%d]", i, name, j))
+				continue
+			}
+			args[name] = i
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// renderIgnition is a CustomizeDiff function which renders a Profile's
+// butane_config or ignition_fragments to Ignition when planning, so transpile
+// and merge errors (and, with butane_strict, Butane warnings) are reported
+// before anything is changed. The Ignition is planned as rendered_ignition
+// unless it's equivalent to the current Ignition. Fields which fragments set
+// differently are planned as ignition_fragment_conflicts, or are errors with
+// ignition_fragments_strict.
+func renderIgnition(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	for _, key := range []string{"butane_config", "butane_files_dir", "ignition_fragments"} {
+		if !d.NewValueKnown(key) {
+			return planUnknownIgnition(d)
+		}
+	}
+	fragments := profileFragments(d.Get("ignition_fragments").([]interface{}))
+	for i := range fragments {
+		if !d.NewValueKnown(fmt.Sprintf("ignition_fragments.%d", i)) {
+			return planUnknownIgnition(d)
+		}
+	}
+
+	var rendered string
+	var conflicts []string
+	var err error
+	if content := d.Get("butane_config").(string); content != "" {
+		rendered, err = transpileButane(content, d.Get("butane_files_dir").(string), d.Get("butane_strict").(bool))
+	} else if len(fragments) > 0 {
+		rendered, conflicts, err = mergeIgnition(fragments)
+	}
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 && d.Get("ignition_fragments_strict").(bool) {
+		return fmt.Errorf("ignition_fragments set the same fields differently:\n%s", strings.Join(conflicts, "\n"))
+	}
+	// computed lists left unset (e.g. empty) would be planned as unknown
+	if err := d.SetNew("ignition_fragment_conflicts", conflicts); err != nil {
+		return err
+	}
+
+	prior := d.Get("rendered_ignition").(string)
+	if rendered == "" || prior == "" {
+		if rendered != prior {
+			return d.SetNew("rendered_ignition", rendered)
+		}
+		return nil
+	}
+	if suppressEquivalentIgnition("rendered_ignition", prior, rendered, nil) {
+		return nil
+	}
+	return d.SetNew("rendered_ignition", rendered)
+}
+
+// planUnknownIgnition plans the Ignition rendered from unknown values as
+// unknown.
+func planUnknownIgnition(d *schema.ResourceDiff) error {
+	if err := d.SetNewComputed("ignition_fragment_conflicts"); err != nil {
+		return err
+	}
+	return d.SetNewComputed("rendered_ignition")
+}
+
+// planIgnitionChanges is a CustomizeDiff function which plans a Profile's
+// ignition_changes, the fields an update changes in its raw or rendered
+// Ignition, so they're shown when planning. The list is kept until the
+// Ignition next changes, so unchanged Profiles don't plan an update.
+func planIgnitionChanges(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" {
+		return d.SetNew("ignition_changes", []string{})
+	}
+	if !d.NewValueKnown("raw_ignition") || !d.NewValueKnown("rendered_ignition") {
+		return d.SetNewComputed("ignition_changes")
+	}
+	// computed lists left unset (e.g. empty) would be planned as unknown
+	prior := d.Get("ignition_changes")
+	if !d.HasChanges("raw_ignition", "rendered_ignition") {
+		return d.SetNew("ignition_changes", prior)
+	}
+
+	oldRaw, newRaw := d.GetChange("raw_ignition")
+	oldRendered, newRendered := d.GetChange("rendered_ignition")
+	old := cmp.Or(oldRaw.(string), oldRendered.(string))
+	new := cmp.Or(newRaw.(string), newRendered.(string))
+	changes := ignitionChanges(old, new)
+	if len(changes) == 0 && old != "" && new != "" {
+		// equivalent Ignition, whose diff is suppressed
+		return d.SetNew("ignition_changes", prior)
+	}
+	return d.SetNew("ignition_changes", changes)
+}
+
+// profileFragments returns a Profile's ignition_fragments.
+func profileFragments(list []interface{}) []string {
+	var fragments []string
+	for _, fragment := range list {
+		fragments = append(fragments, fragment.(string))
+	}
+	return fragments
+}
+
+// initrdName returns the name iPXE loads an initrd entry as, which kernel
+// initrd= arguments refer to.
+func initrdName(initrd string) string {
+	fields := strings.Fields(initrd)
+	for i, field := range fields {
+		if (field == "--name" || field == "-n") && i+1 < len(fields) {
+			return fields[i+1]
+		}
+		if name, ok := strings.CutPrefix(field, "--name="); ok {
+			return name
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	location := fields[len(fields)-1]
+	if u, err := url.Parse(location); err == nil {
+		location = u.Path
+	}
+	return path.Base(location)
+}
+
+// resourceProfileRead reads a Profile and its associated configs from each
+// replica. The Profile needs creating if every replica is missing it and
+// needs updating if any replica is missing it or differs from the first.
+func resourceProfileRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	replicas := meta.(Replicas)
+
+	name := d.Get("name").(string)
+	var current *profileState
+	var first string
+	var missing, outOfSync []string
+	for _, client := range replicas {
+		state, readDiags := readProfile(ctx, client, name)
+		if readDiags.HasError() {
+			return readDiags
+		}
+		switch {
+		case state == nil:
+			missing = append(missing, client.Endpoint)
+		case current == nil:
+			current, first = state, client.Endpoint
+		case !reflect.DeepEqual(state, current):
+			outOfSync = append(outOfSync, client.Endpoint)
+		}
+	}
+
+	if current == nil {
+		// resource doesn't exist and needs creating
 		d.SetId("")
 		return diags
 	}
 
-	profile := profileGetResponse.Profile
-	if err := d.Set("kernel", profile.Boot.Kernel); err != nil {
-		return diag.FromErr(err)
+	if len(missing) > 0 {
+		diags = append(diags, outOfSyncDiagnostic("Profile", name, "is missing", missing))
 	}
-	if err := d.Set("initrd", profile.Boot.Initrd); err != nil {
+	if len(outOfSync) > 0 {
+		diags = append(diags, outOfSyncDiagnostic("Profile", name, "differs from "+first, outOfSync))
+	}
+	if err := d.Set("replicas_in_sync", len(missing)+len(outOfSync) == 0); err != nil {
 		return diag.FromErr(err)
 	}
-	if err := d.Set("args", profile.Boot.Args); err != nil {
+	if err := current.set(d); err != nil {
 		return diag.FromErr(err)
 	}
+	return diags
+}
+
+// readProfile reads a Profile and its associated configs. If the Profile or
+// its configs don't exist, nil is returned.
+func readProfile(ctx context.Context, client *Client, name string) (*profileState, diag.Diagnostics) {
+	profileGetResponse, err := client.Profiles.ProfileGet(ctx, &serverpb.ProfileGetRequest{
+		Id: name,
+	})
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, rpcDiagnostics(client, "ProfileGet", name, err)
+	}
+
+	profile := profileGetResponse.Profile
+	state := &profileState{
+		Kernel: profile.GetBoot().GetKernel(),
+		Initrd: profile.GetBoot().GetInitrd(),
+		Args:   profile.GetBoot().GetArgs(),
+	}
 
 	if profile.IgnitionId != "" {
 		ignition, err := client.Ignition.IgnitionGet(ctx, &serverpb.IgnitionGetRequest{
 			Name: profile.IgnitionId,
 		})
-		if err != nil {
-			// resource doesn't exist or is corrupted and needs creating
-			d.SetId("")
-			return diags
+		if isNotFound(err) {
+			// resource is missing its config and needs creating
+			return nil, nil
+		} else if err != nil {
+			return nil, rpcDiagnostics(client, "IgnitionGet", profile.IgnitionId, err)
 		}
 		// .ign and .ignition files indicate raw ignition,
 		// see https://github.com/poseidon/matchbox/blob/d6bb21d5853e7af7c3c54b74537176caf5460482/matchbox/http/ignition.go#L18
 		if strings.HasSuffix(profile.IgnitionId, ".ign") || strings.HasSuffix(profile.IgnitionId, ".ignition") {
-			err = d.Set("raw_ignition", string(ignition.Config))
+			state.RawIgnition = string(ignition.Config)
 		} else {
-			err = d.Set("container_linux_config", string(ignition.Config))
-		}
-		if err != nil {
-			return diag.FromErr(err)
+			state.ContainerLinuxConfig = string(ignition.Config)
 		}
 	}
 
 	if profile.GenericId != "" {
-		ignition, err := client.Generic.GenericGet(ctx, &serverpb.GenericGetRequest{
+		generic, err := client.Generic.GenericGet(ctx, &serverpb.GenericGetRequest{
 			Name: profile.GenericId,
 		})
-		if err != nil {
-			// resource doesn't exist or is corrupted and needs creating
-			d.SetId("")
-			return diags
+		if isNotFound(err) {
+			// resource is missing its config and needs creating
+			return nil, nil
+		} else if err != nil {
+			return nil, rpcDiagnostics(client, "GenericGet", profile.GenericId, err)
 		}
-		if err := d.Set("generic_config", string(ignition.Config)); err != nil {
-			return diag.FromErr(err)
+		state.GenericConfig = string(generic.Config)
+	}
+
+	return state, nil
+}
+
+// resourceProfileImport imports a Profile by id. Read fetches the Profile and
+// its Container Linux Config, raw Ignition, or generic config. Profiles must
+// reference configs named after the Profile, since updates and deletes manage
+// configs by those names.
+func resourceProfileImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	replicas := meta.(Replicas)
+
+	for _, client := range replicas {
+		profileGetResponse, err := client.Profiles.ProfileGet(ctx, &serverpb.ProfileGetRequest{
+			Id: d.Id(),
+		})
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("ProfileGet %q on %s: %v", d.Id(), client.Endpoint, err)
+		}
+		if err := checkProfileConfigNames(profileGetResponse.Profile); err != nil {
+			return nil, fmt.Errorf("%s: %v", client.Endpoint, err)
 		}
 	}
 
-	return diags
+	if err := d.Set("name", d.Id()); err != nil {
+		return nil, err
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// checkProfileConfigNames checks a Profile references configs by the names
+// the provider gives them (e.g. name.yaml.tmpl or name.ign).
+func checkProfileConfigNames(profile *storagepb.Profile) error {
+	name := profile.GetId()
+	switch profile.GetIgnitionId() {
+	case "", name + ".yaml.tmpl", name + ".ign":
+	default:
+		return fmt.Errorf("profile %q references Ignition config %q, but only profiles with configs named %s.yaml.tmpl or %s.ign can be imported", name, profile.GetIgnitionId(), name, name)
+	}
+	switch profile.GetGenericId() {
+	case "", name:
+	default:
+		return fmt.Errorf("profile %q references generic config %q, but only profiles with a generic config named %s can be imported", name, profile.GetGenericId(), name)
+	}
+	return nil
 }
 
-// resourceProfileDelete deletes a Profile and its associated configs. Partial
-// deletes leave state unchanged and can be retried (deleting resources which
-// no longer exist is a no-op).
+// resourceProfileDelete deletes a Profile and its associated configs from each
+// replica. Partial deletes leave state unchanged and can be retried (deleting
+// resources which no longer exist is a no-op).
 func resourceProfileDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	replicas := meta.(Replicas)
+
+	diags := replicas.Each(func(client *Client) diag.Diagnostics {
+		return deleteProfile(ctx, d, client)
+	})
+	if diags.HasError() {
+		return diags
+	}
+
+	// resource can be destroyed in state
+	d.SetId("")
+	return diags
+}
+
+func deleteProfile(ctx context.Context, d *schema.ResourceData, client *Client) diag.Diagnostics {
 	var diags diag.Diagnostics
-	client := meta.(*matchbox.Client)
 
 	// Profile
 	name := d.Get("name").(string)
 	_, err := client.Profiles.ProfileDelete(ctx, &serverpb.ProfileDeleteRequest{
 		Id: name,
 	})
-	if err != nil {
-		return diag.FromErr(err)
+	if err != nil && !isNotFound(err) {
+		return rpcDiagnostics(client, "ProfileDelete", name, err)
 	}
 
 	// Container Linux Config
@@ -231,8 +774,8 @@ func resourceProfileDelete(ctx context.Context, d *schema.ResourceData, meta int
 		_, err = client.Ignition.IgnitionDelete(ctx, &serverpb.IgnitionDeleteRequest{
 			Name: name,
 		})
-		if err != nil {
-			return diag.FromErr(err)
+		if err != nil && !isNotFound(err) {
+			return rpcDiagnostics(client, "IgnitionDelete", name, err)
 		}
 	}
 
@@ -241,13 +784,11 @@ func resourceProfileDelete(ctx context.Context, d *schema.ResourceData, meta int
 		_, err = client.Generic.GenericDelete(ctx, &serverpb.GenericDeleteRequest{
 			Name: name,
 		})
-		if err != nil {
-			return diag.FromErr(err)
+		if err != nil && !isNotFound(err) {
+			return rpcDiagnostics(client, "GenericDelete", name, err)
 		}
 	}
 
-	// resource can be destroyed in state
-	d.SetId("")
 	return diags
 }
 
@@ -263,9 +804,34 @@ func containerLinuxConfig(d *schema.ResourceData) (filename, config string) {
 		return fmt.Sprintf("%s.ign", name), content.(string)
 	}
 
+	// Ignition transpiled from butane_config
+	if content, ok := d.GetOk("rendered_ignition"); ok {
+		return fmt.Sprintf("%s.ign", name), content.(string)
+	}
+
 	return
 }
 
+// priorContainerLinuxConfigName returns the Container Linux Config or Ignition
+// filename recorded in prior state.
+func priorContainerLinuxConfigName(d *schema.ResourceData) string {
+	name := d.Get("name").(string)
+
+	if prior, _ := d.GetChange("container_linux_config"); prior.(string) != "" {
+		return fmt.Sprintf("%s.yaml.tmpl", name)
+	}
+
+	if prior, _ := d.GetChange("raw_ignition"); prior.(string) != "" {
+		return fmt.Sprintf("%s.ign", name)
+	}
+
+	if prior, _ := d.GetChange("rendered_ignition"); prior.(string) != "" {
+		return fmt.Sprintf("%s.ign", name)
+	}
+
+	return ""
+}
+
 func genericConfig(d *schema.ResourceData) (filename, config string) {
 	// use profile name to generate generic config filename
 	name := d.Get("name").(string)
@@ -276,3 +842,74 @@ func genericConfig(d *schema.ResourceData) (filename, config string) {
 
 	return
 }
+
+// profileFromResourceData returns the matchbox Profile described by the
+// resource.
+func profileFromResourceData(d *schema.ResourceData) *storagepb.Profile {
+	// NetBoot
+	var initrds []string
+	for _, initrd := range d.Get("initrd").([]interface{}) {
+		initrds = append(initrds, initrd.(string))
+	}
+	var args []string
+	for _, arg := range d.Get("args").([]interface{}) {
+		args = append(args, arg.(string))
+	}
+	// Container Linux config / Ignition config
+	clcName, _ := containerLinuxConfig(d)
+	// Generic (experimental) config
+	genericName, _ := genericConfig(d)
+
+	return &storagepb.Profile{
+		Id: d.Get("name").(string),
+		Boot: &storagepb.NetBoot{
+			Kernel: d.Get("kernel").(string),
+			Initrd: initrds,
+			Args:   args,
+		},
+		IgnitionId: clcName,
+		GenericId:  genericName,
+	}
+}
+
+// profileState holds a Profile's attributes for comparison between replicas.
+type profileState struct {
+	Kernel               string
+	Initrd               []string
+	Args                 []string
+	ContainerLinuxConfig string
+	RawIgnition          string
+	GenericConfig        string
+}
+
+// set sets the resource's attributes from the profileState. Raw Ignition is
+// set as rendered_ignition for Profiles configured with butane_config or
+// ignition_fragments.
+func (s *profileState) set(d *schema.ResourceData) error {
+	if err := d.Set("kernel", s.Kernel); err != nil {
+		return err
+	}
+	if err := d.Set("initrd", s.Initrd); err != nil {
+		return err
+	}
+	if err := d.Set("args", s.Args); err != nil {
+		return err
+	}
+	if err := d.Set("container_linux_config", s.ContainerLinuxConfig); err != nil {
+		return err
+	}
+	rawIgnition, renderedIgnition := s.RawIgnition, ""
+	if d.Get("butane_config").(string) != "" || len(d.Get("ignition_fragments").([]interface{})) > 0 {
+		rawIgnition, renderedIgnition = "", s.RawIgnition
+	}
+	if err := d.Set("raw_ignition", rawIgnition); err != nil {
+		return err
+	}
+	if err := d.Set("rendered_ignition", renderedIgnition); err != nil {
+		return err
+	}
+	if err := d.Set("template_keys", profileTemplateKeys(s.ContainerLinuxConfig, s.GenericConfig)); err != nil {
+		return err
+	}
+	return d.Set("generic_config", s.GenericConfig)
+}
@@ -3,15 +3,17 @@ package matchbox
 import (
 	"fmt"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
-	"github.com/poseidon/matchbox/matchbox/storage/testfakes"
+	"github.com/poseidon/matchbox/matchbox/storage/storagepb"
 )
 
 func TestResourceProfile(t *testing.T) {
-	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, testfakes.NewFixedStore())
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
 	go func() {
 		err := srv.Start()
 		if err != nil {
@@ -97,7 +99,7 @@ func TestResourceProfile(t *testing.T) {
 }
 
 func TestResourceProfile_withIgnition(t *testing.T) {
-	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, testfakes.NewFixedStore())
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
 	go func() {
 		err := srv.Start()
 		if err != nil {
@@ -119,7 +121,7 @@ func TestResourceProfile_withIgnition(t *testing.T) {
 				"qux",
 			]
 
-			raw_ignition = "baz"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
 		}
 	`
 
@@ -137,8 +139,8 @@ func TestResourceProfile_withIgnition(t *testing.T) {
 		if err != nil {
 			return fmt.Errorf("failed to get raw Ignition config: %v", err)
 		}
-		if ignition != "baz" {
-			return fmt.Errorf("want raw Ignition 'baz', got %q", ignition)
+		if ignition != `{"ignition":{"version":"3.3.0"}}` {
+			return fmt.Errorf("want raw Ignition version 3.3.0, got %q", ignition)
 		}
 
 		return nil
@@ -155,7 +157,7 @@ func TestResourceProfile_withIgnition(t *testing.T) {
 }
 
 func TestResourceProfile_withIgnitionAndContainerLinuxConfig(t *testing.T) {
-	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, testfakes.NewFixedStore())
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
 	go func() {
 		err := srv.Start()
 		if err != nil {
@@ -168,7 +170,7 @@ func TestResourceProfile_withIgnitionAndContainerLinuxConfig(t *testing.T) {
 		resource "matchbox_profile" "default" {
 			name   = "default"
 			container_linux_config = "baz"
-			raw_ignition = "baz"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
 		}
 	`
 
@@ -176,7 +178,72 @@ func TestResourceProfile_withIgnitionAndContainerLinuxConfig(t *testing.T) {
 		ProviderFactories: testProviderFactories,
 		Steps: []resource.TestStep{{
 			Config:      srv.AddProviderConfig(hcl),
-			ExpectError: regexp.MustCompile("are mutually exclusive"),
+			ExpectError: regexp.MustCompile(`"container_linux_config": conflicts with raw_ignition`),
+		}},
+	})
+}
+
+// TestResourceProfile_Validation checks invalid profiles are rejected when
+// planning, with the attribute at fault.
+func TestResourceProfile_Validation(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	cases := []struct {
+		attrs    string
+		expected string
+	}{
+		{`args = ["console=ttyS0"]`, `"args": all of ` + "`args,kernel`" + ` must be specified`},
+		{`initrd = ["initramfs.img"]`, `"initrd": all of ` + "`initrd,kernel`" + ` must be specified`},
+		{`kernel = "vmlinuz"
+			args = ["console=ttyS0", ""]`, `expected "args.1" to not be an empty string`},
+		{`kernel = "vmlinuz"
+			initrd = ["--name main http://example.com/a.img", "-n main http://example.com/b.img"]`, `initrd\[1\]: duplicate initrd name "main", also used by initrd\[0\]`},
+		{`kernel = "vmlinuz"
+			initrd = ["http://example.com/a/initramfs.img", "http://example.com/b/initramfs.img?v=2"]`, `initrd\[1\]: duplicate initrd name "initramfs.img"`},
+		{`kernel = "vmlinuz"
+			args = ["initrd=main", "console=ttyS0", "initrd=main"]`, `args\[2\]: duplicate initrd=main, also set by args\[0\]`},
+		{`raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"},\"storage\":{\"files\":[{\"path\":\"etc/hostname\"}]}}"`, `at \$\.storage\.files\.0\.path, line 1 col 61: path not absolute`},
+		{`raw_ignition = "{\"ignition\":{\"version\":\"2.2.0\"}}"`, `unsupported Ignition version 2\.2\.0, matchbox serves spec 3\.0\.0 to 3\.4\.0\s+configs`},
+		{`container_linux_config = "hostname: {{.hostname"`, `unclosed action`},
+		{`generic_config = "{{if .mac}}"`, `unexpected EOF`},
+	}
+	for _, c := range cases {
+		hcl := fmt.Sprintf(`
+			resource "matchbox_profile" "default" {
+				name = "default"
+				%s
+			}
+		`, c.attrs)
+		resource.UnitTest(t, resource.TestCase{
+			ProviderFactories: testProviderFactories,
+			Steps: []resource.TestStep{{
+				Config:      srv.AddProviderConfig(hcl),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(c.expected),
+			}},
+		})
+	}
+
+	// initrd= arguments may refer to distinct initrds
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "vmlinuz"
+			initrd = ["--name main http://example.com/a.img", "http://example.com/b.img"]
+			args   = ["initrd=main", "initrd=b.img"]
+		}
+	`
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{{
+			Config: srv.AddProviderConfig(hcl),
 		}},
 	})
 }
@@ -184,7 +251,7 @@ func TestResourceProfile_withIgnitionAndContainerLinuxConfig(t *testing.T) {
 // TestResourceProfile_Read checks the provider compares the desired state with
 // the actual matchbox state
 func TestResourceProfile_Read(t *testing.T) {
-	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, testfakes.NewFixedStore())
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
 	go func() {
 		err := srv.Start()
 		if err != nil {
@@ -206,7 +273,7 @@ func TestResourceProfile_Read(t *testing.T) {
 				"qux",
 			]
 
-			raw_ignition = "baz"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
 		}
 	`
 
@@ -229,3 +296,678 @@ func TestResourceProfile_Read(t *testing.T) {
 		},
 	})
 }
+
+// TestResourceProfile_Update checks a Profile and its configs are updated
+// in-place and configs which are no longer referenced are removed.
+func TestResourceProfile_Update(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			args = [
+				"qux",
+			]
+			container_linux_config = "baz"
+			generic_config = "experimental"
+		}
+	`
+
+	updated := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			args = [
+				"qux",
+				"bux",
+			]
+			raw_ignition = "{\"ignition\":{\"version\":\"3.4.0\"}}"
+		}
+	`
+
+	check := func(s *terraform.State) error {
+		profile, err := srv.Store.ProfileGet("default")
+		if err != nil {
+			return err
+		}
+
+		args := profile.GetBoot().GetArgs()
+		if len(args) != 2 || args[1] != "bux" {
+			return fmt.Errorf("args, found %v", args)
+		}
+
+		if profile.GetIgnitionId() != "default.ign" {
+			return fmt.Errorf("profile, found %q", profile.GetIgnitionId())
+		}
+
+		if profile.GetGenericId() != "" {
+			return fmt.Errorf("generic, found %q", profile.GetGenericId())
+		}
+
+		ignition, err := srv.Store.IgnitionGet("default.ign")
+		if err != nil {
+			return fmt.Errorf("failed to get raw Ignition config: %v", err)
+		}
+		if ignition != `{"ignition":{"version":"3.4.0"}}` {
+			return fmt.Errorf("want raw Ignition version 3.4.0, got %q", ignition)
+		}
+
+		if _, err := srv.Store.IgnitionGet("default.yaml.tmpl"); err == nil {
+			return fmt.Errorf("want stale Container Linux config removed")
+		}
+
+		if _, err := srv.Store.GenericGet("default"); err == nil {
+			return fmt.Errorf("want stale generic config removed")
+		}
+
+		return nil
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl),
+			},
+			{
+				Config: srv.AddProviderConfig(updated),
+				Check:  check,
+			},
+		},
+	})
+}
+
+// TestResourceProfile_Import checks Profiles created outside Terraform can be
+// imported along with their configs.
+func TestResourceProfile_Import(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+
+			initrd = [
+				"bar",
+			]
+
+			args = [
+				"qux",
+			]
+
+			container_linux_config = "baz"
+			generic_config = "experimental"
+		}
+
+		resource "matchbox_profile" "ignition" {
+			name         = "ignition"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
+		}
+	`
+
+	// configs not named after the profile can't be managed
+	if err := srv.Store.ProfilePut(&storagepb.Profile{Id: "shared", IgnitionId: "base.ign"}); err != nil {
+		t.Fatalf("ProfilePut: %v", err)
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl),
+			},
+			{
+				ResourceName:      "matchbox_profile.default",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+			{
+				ResourceName:      "matchbox_profile.ignition",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+			{
+				ResourceName:  "matchbox_profile.default",
+				ImportState:   true,
+				ImportStateId: "missing",
+				ExpectError:   regexp.MustCompile("Cannot import non-existent remote object"),
+			},
+			{
+				ResourceName:  "matchbox_profile.default",
+				ImportState:   true,
+				ImportStateId: "shared",
+				ExpectError:   regexp.MustCompile(`profile "shared" references Ignition config "base.ign"`),
+			},
+		},
+	})
+}
+
+// TestResourceProfile_IgnitionFormatting checks reformatted Ignition content
+// is compared semantically and doesn't plan an update.
+func TestResourceProfile_IgnitionFormatting(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"},\"storage\":{\"files\":[{\"path\":\"/etc/hostname\",\"mode\":420}]}}"
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl),
+			},
+			{
+				PreConfig: func() {
+					srv.Store.(*FixedStore).IgnitionConfigs["default.ign"] = `{
+  "storage": {"files": [{"mode": 420, "path": "/etc/hostname"}]},
+  "ignition": {"version": "3.3.0"}
+}`
+				},
+				Config:   srv.AddProviderConfig(hcl),
+				PlanOnly: true,
+			},
+			{
+				PreConfig: func() {
+					srv.Store.(*FixedStore).IgnitionConfigs["default.ign"] = `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/hostname","mode":384}]}}`
+				},
+				Config:             srv.AddProviderConfig(hcl),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+// TestResourceProfile_IgnitionChanges checks the fields an update changes in
+// a Profile's Ignition are planned as ignition_changes.
+func TestResourceProfile_IgnitionChanges(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"},\"storage\":{\"files\":[{\"path\":\"/etc/hostname\",\"mode\":420}]}}"
+		}
+	`
+
+	updated := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"},\"storage\":{\"files\":[{\"path\":\"/etc/hostname\",\"mode\":384,\"contents\":{\"source\":\"data:,node1\"}}]}}"
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl),
+				Check:  resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_changes.#", "0"),
+			},
+			{
+				Config: srv.AddProviderConfig(updated),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_changes.#", "2"),
+					resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_changes.0", "storage.files[0].contents added"),
+					resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_changes.1", "storage.files[0].mode changed"),
+				),
+			},
+			{
+				Config:   srv.AddProviderConfig(updated),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+// TestResourceProfile_Butane checks butane_config is transpiled to Ignition
+// when planning and stored as raw Ignition.
+func TestResourceProfile_Butane(t *testing.T) {
+	fixed := NewFixedStore()
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, fixed)
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			butane_config = <<-EOT
+				variant: fcos
+				version: 1.5.0
+				storage:
+				  files:
+				    - path: /etc/hostname
+				      contents:
+				        inline: node1
+			EOT
+		}
+	`
+
+	strict := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			butane_config = "variant: fcos\nversion: 1.5.0\nfoo: bar\n"
+			butane_strict = true
+		}
+	`
+
+	raw := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.4.0\"}}"
+		}
+	`
+
+	clc := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			container_linux_config = "hostname: {{.hostname}}"
+		}
+	`
+
+	check := func(s *terraform.State) error {
+		profile, err := srv.Store.ProfileGet("default")
+		if err != nil {
+			return err
+		}
+		if profile.GetIgnitionId() != "default.ign" {
+			return fmt.Errorf("profile, found %q", profile.GetIgnitionId())
+		}
+		ignition, err := srv.Store.IgnitionGet("default.ign")
+		if err != nil {
+			return fmt.Errorf("failed to get Ignition config: %v", err)
+		}
+		if !strings.Contains(ignition, `"version":"3.4.0"`) || !strings.Contains(ignition, `"source":"data:,node1"`) {
+			return fmt.Errorf("want Ignition transpiled from Butane, got %q", ignition)
+		}
+		return nil
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl),
+				Check: resource.ComposeTestCheckFunc(
+					check,
+					resource.TestCheckNoResourceAttr("matchbox_profile.default", "raw_ignition"),
+					resource.TestCheckResourceAttrSet("matchbox_profile.default", "rendered_ignition"),
+				),
+			},
+			{
+				Config:   srv.AddProviderConfig(hcl),
+				PlanOnly: true,
+			},
+			{
+				PreConfig: func() {
+					fixed.IgnitionConfigs["default.ign"] = `{"ignition":{"version":"3.4.0"}}`
+				},
+				Config:             srv.AddProviderConfig(hcl),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: srv.AddProviderConfig(hcl),
+				Check:  check,
+			},
+			{
+				Config: srv.AddProviderConfig(raw),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("matchbox_profile.default", "raw_ignition", `{"ignition":{"version":"3.4.0"}}`),
+					resource.TestCheckResourceAttr("matchbox_profile.default", "rendered_ignition", ""),
+				),
+			},
+			{
+				Config: srv.AddProviderConfig(hcl),
+				Check:  check,
+			},
+			{
+				Config: srv.AddProviderConfig(clc),
+				Check:  resource.TestCheckResourceAttr("matchbox_profile.default", "rendered_ignition", ""),
+			},
+			{
+				Config:      srv.AddProviderConfig(strict),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`Unused key foo`),
+			},
+		},
+	})
+}
+
+// TestResourceProfile_IgnitionFragments checks ignition_fragments are merged
+// into one Ignition config and conflicting fields are planned (or are errors
+// with ignition_fragments_strict).
+func TestResourceProfile_IgnitionFragments(t *testing.T) {
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, NewFixedStore())
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			ignition_fragments = [
+				jsonencode({
+					ignition = { version = "3.3.0" }
+					storage  = { files = [{ path = "/etc/motd", mode = 420 }] }
+				}),
+				jsonencode({
+					ignition = { version = "3.4.0" }
+					systemd  = { units = [{ name = "kubelet.service", enabled = true }] }
+				}),
+			]
+		}
+	`
+
+	invalid := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			ignition_fragments = [
+				jsonencode({
+					ignition = { version = "3.3.0" }
+					storage  = { files = [{ path = "etc/motd" }] }
+				}),
+			]
+		}
+	`
+
+	conflicting := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			ignition_fragments = [
+				jsonencode({
+					ignition = { version = "3.3.0" }
+					storage  = { files = [{ path = "/etc/motd", mode = 420 }] }
+				}),
+				jsonencode({
+					ignition = { version = "3.4.0" }
+					storage  = { files = [{ path = "/etc/motd", mode = 384 }] }
+				}),
+			]
+			%s
+		}
+	`
+
+	check := func(s *terraform.State) error {
+		ignition, err := srv.Store.IgnitionGet("default.ign")
+		if err != nil {
+			return fmt.Errorf("failed to get Ignition config: %v", err)
+		}
+		expected := `{"ignition":{"version":"3.4.0"},"storage":{"files":[{"mode":420,"path":"/etc/motd"}]},"systemd":{"units":[{"enabled":true,"name":"kubelet.service"}]}}`
+		if ignition != expected {
+			return fmt.Errorf("want merged Ignition %s, got %s", expected, ignition)
+		}
+		return nil
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      srv.AddProviderConfig(invalid),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`at \$\.storage\.files\.0\.path, line 1 col \d+: path not absolute`),
+			},
+			{
+				Config:      srv.AddProviderConfig(fmt.Sprintf(conflicting, "ignition_fragments_strict = true")),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`storage\.files\[/etc/motd\]\.mode is set by ignition_fragments\[0\] and\s+overridden by ignition_fragments\[1\]`),
+			},
+			{
+				Config: srv.AddProviderConfig(hcl),
+				Check: resource.ComposeTestCheckFunc(
+					check,
+					resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_fragment_conflicts.#", "0"),
+				),
+			},
+			{
+				Config:   srv.AddProviderConfig(hcl),
+				PlanOnly: true,
+			},
+			{
+				Config: srv.AddProviderConfig(fmt.Sprintf(conflicting, "")),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_fragment_conflicts.#", "1"),
+					resource.TestCheckResourceAttr("matchbox_profile.default", "ignition_fragment_conflicts.0", "storage.files[/etc/motd].mode is set by ignition_fragments[0] and overridden by ignition_fragments[1]"),
+				),
+			},
+		},
+	})
+}
+
+// TestResourceProfile_ReadError checks Profiles are only removed from state
+// when matchbox reports them missing, not when reads fail.
+func TestResourceProfile_ReadError(t *testing.T) {
+	store := &BreakableStore{Store: NewFixedStore()}
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, store)
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: srv.AddProviderConfig(hcl),
+			},
+			{
+				PreConfig: func() {
+					store.BrokenReads = true
+				},
+				Config:      srv.AddProviderConfig(hcl),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`ProfileGet "default" on 127.0.0.1:\d+`),
+			},
+			{
+				PreConfig: func() {
+					store.BrokenReads = false
+				},
+				Config:   srv.AddProviderConfig(hcl),
+				PlanOnly: true,
+			},
+			{
+				PreConfig: func() {
+					delete(store.Store.(*FixedStore).Profiles, "default")
+				},
+				Config:             srv.AddProviderConfig(hcl),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+// TestResourceProfile_Timeouts checks profile operations are bounded by the
+// resource's timeouts.
+func TestResourceProfile_Timeouts(t *testing.T) {
+	store := &BreakableStore{Store: NewFixedStore()}
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, store)
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+
+			timeouts {
+				create = "1s"
+			}
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				PreConfig: func() {
+					store.WriteDelay = 2 * time.Second
+				},
+				Config:      srv.AddProviderConfig(hcl),
+				ExpectError: regexp.MustCompile(`exceeded its timeout`),
+			},
+			{
+				PreConfig: func() {
+					store.WriteDelay = 0
+				},
+				Config: srv.AddProviderConfig(hcl),
+				Check:  resource.TestCheckResourceAttr("matchbox_profile.default", "kernel", "foo"),
+			},
+		},
+	})
+}
+
+// TestResourceProfile_CreateRollback checks configs written before a failed
+// Profile create are removed (or restored, if they existed), unless the
+// Profile may have been created.
+func TestResourceProfile_CreateRollback(t *testing.T) {
+	fixed := NewFixedStore()
+	store := &BreakableStore{Store: fixed}
+	srv := NewFixtureServer(clientTLSInfo, serverTLSInfo, store)
+	go func() {
+		err := srv.Start()
+		if err != nil {
+			t.Errorf("fixture server start: %v", err)
+		}
+	}()
+	defer srv.Stop()
+
+	hcl := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			container_linux_config = "baz"
+			generic_config = "experimental"
+		}
+	`
+	timeout := `
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			container_linux_config = "baz"
+			generic_config = "experimental"
+
+			timeouts {
+				create = "1s"
+			}
+		}
+	`
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				PreConfig: func() {
+					// same-named config which existed before the create
+					fixed.GenericConfigs["default"] = "prior"
+					store.BrokenWrites = true
+				},
+				Config:      srv.AddProviderConfig(hcl),
+				ExpectError: regexp.MustCompile(`ProfilePut "default" on 127.0.0.1:\d+`),
+			},
+			{
+				PreConfig: func() {
+					if len(fixed.Profiles) != 0 || len(fixed.IgnitionConfigs) != 0 || fixed.GenericConfigs["default"] != "prior" {
+						t.Errorf("expected failed create to be rolled back, got profiles %v, ignition %v, generic %v",
+							fixed.Profiles, fixed.IgnitionConfigs, fixed.GenericConfigs)
+					}
+					store.BrokenWrites = false
+					store.WriteDelay = 2 * time.Second
+				},
+				Config:      srv.AddProviderConfig(timeout),
+				ExpectError: regexp.MustCompile(`Matchbox create outcome unknown`),
+			},
+			{
+				PreConfig: func() {
+					// the timed out put is still applied
+					store.Wait()
+					if _, ok := fixed.Profiles["default"]; !ok {
+						t.Errorf("expected delayed Profile put to be applied, got profiles %v", fixed.Profiles)
+					}
+					if _, ok := fixed.IgnitionConfigs["default.yaml.tmpl"]; !ok {
+						t.Errorf("expected configs of a possibly created Profile to be kept, got ignition %v", fixed.IgnitionConfigs)
+					}
+					store.WriteDelay = 0
+				},
+				Config: srv.AddProviderConfig(hcl),
+				Check: func(s *terraform.State) error {
+					if _, ok := fixed.IgnitionConfigs["default.yaml.tmpl"]; !ok {
+						return fmt.Errorf("expected Ignition config default.yaml.tmpl, got %v", fixed.IgnitionConfigs)
+					}
+					if fixed.GenericConfigs["default"] != "experimental" {
+						return fmt.Errorf("expected generic config default, got %v", fixed.GenericConfigs)
+					}
+					return nil
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,89 @@
+package matchbox
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy configures retrying matchbox RPCs which fail with transient
+// errors (e.g. while matchbox restarts).
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per RPC, including the
+	// first. Zero or one disables retries.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubling after each attempt
+	Backoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// Codes are the retryable gRPC status codes
+	Codes []codes.Code
+}
+
+// parseCode parses a gRPC status code name (e.g. UNAVAILABLE).
+func parseCode(name string) (codes.Code, error) {
+	var code codes.Code
+	err := code.UnmarshalJSON([]byte(strconv.Quote(name)))
+	return code, err
+}
+
+type noRetryKey struct{}
+
+// withoutRetry returns a context whose RPCs aren't retried.
+func withoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// retryInterceptor returns a gRPC interceptor which retries unary RPCs that
+// fail with a retryable code, waiting an exponential backoff with jitter
+// between attempts. Retries stop early if the RPC context is done.
+func retryInterceptor(policy RetryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if ctx.Value(noRetryKey{}) != nil {
+			return err
+		}
+
+		backoff := policy.Backoff
+		for attempt := 1; attempt < policy.MaxAttempts && policy.retryable(err); attempt++ {
+			// wait between half and all of the backoff
+			var wait time.Duration
+			if backoff > 0 {
+				wait = backoff/2 + rand.N(backoff/2+1)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			backoff *= 2
+			if policy.MaxBackoff > 0 {
+				backoff = min(backoff, policy.MaxBackoff)
+			}
+		}
+		return err
+	}
+}
+
+// retryable returns true if the RPC error has a retryable code.
+func (p RetryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := status.Code(err)
+	for _, c := range p.Codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package matchbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryInterceptor(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+		Codes:       []codes.Code{codes.Unavailable},
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name     string
+		ctx      context.Context
+		errs     []error
+		attempts int
+		code     codes.Code
+	}{
+		{"success", context.Background(), nil, 1, codes.OK},
+		{"retry until success", context.Background(), []error{status.Error(codes.Unavailable, "restarting"), status.Error(codes.Unavailable, "restarting")}, 3, codes.OK},
+		{"max attempts", context.Background(), []error{status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down")}, 3, codes.Unavailable},
+		{"not retryable", context.Background(), []error{status.Error(codes.Unknown, "bad request")}, 1, codes.Unknown},
+		{"without retry", withoutRetry(context.Background()), []error{status.Error(codes.Unavailable, "down")}, 1, codes.Unavailable},
+		{"canceled", canceled, []error{status.Error(codes.Unavailable, "down")}, 1, codes.Unavailable},
+	}
+	for _, c := range cases {
+		attempts := 0
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			if attempts <= len(c.errs) {
+				return c.errs[attempts-1]
+			}
+			return nil
+		}
+		err := retryInterceptor(policy)(c.ctx, "/rpcpb.Profiles/ProfilePut", nil, nil, nil, invoker)
+		if attempts != c.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", c.name, c.attempts, attempts)
+		}
+		if status.Code(err) != c.code {
+			t.Errorf("%s: expected code %v, got %v", c.name, c.code, err)
+		}
+	}
+}
+
+// TestRetryInterceptor_noBackoff checks retries without a backoff don't wait.
+func TestRetryInterceptor_noBackoff(t *testing.T) {
+	for _, backoff := range []time.Duration{0, -time.Second} {
+		attempts := 0
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			if attempts < 3 {
+				return status.Error(codes.Unavailable, "restarting")
+			}
+			return nil
+		}
+		policy := RetryPolicy{MaxAttempts: 3, Backoff: backoff, Codes: []codes.Code{codes.Unavailable}}
+		err := retryInterceptor(policy)(context.Background(), "/rpcpb.Profiles/ProfilePut", nil, nil, nil, invoker)
+		if err != nil || attempts != 3 {
+			t.Errorf("backoff %v: expected success after 3 attempts, got %v after %d", backoff, err, attempts)
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected codes.Code
+		ok       bool
+	}{
+		{"UNAVAILABLE", codes.Unavailable, true},
+		{"DEADLINE_EXCEEDED", codes.DeadlineExceeded, true},
+		{"RESOURCE_EXHAUSTED", codes.ResourceExhausted, true},
+		{"Unavailable", 0, false},
+		{"NOPE", 0, false},
+	}
+	for _, c := range cases {
+		code, err := parseCode(c.name)
+		if c.ok && (err != nil || code != c.expected) {
+			t.Errorf("parseCode(%q): expected %v, got %v, %v", c.name, c.expected, code, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("parseCode(%q): expected error, got %v", c.name, code)
+		}
+	}
+}
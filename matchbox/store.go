@@ -0,0 +1,195 @@
+package matchbox
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/poseidon/matchbox/matchbox/server"
+	"github.com/poseidon/matchbox/matchbox/server/serverpb"
+	"github.com/poseidon/matchbox/matchbox/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewDataPathClient returns a Client which reads and writes a matchbox data
+// directory (i.e. matchbox -data-path) instead of using the gRPC API.
+func NewDataPathClient(dataPath string) *Client {
+	store := storage.NewFileStore(&storage.Config{
+		Root: dataPath,
+	})
+	client := NewStoreClient(store)
+	client.Endpoint = dataPath
+	return client
+}
+
+// NewStoreClient returns a Client which reads and writes a matchbox
+// storage.Store. Requests are validated by a matchbox server.Server, as they
+// would be by the gRPC API, and fail if their context is done (e.g. a
+// resource timeout elapsed).
+func NewStoreClient(store storage.Store) *Client {
+	srv := server.NewServer(&server.Config{Store: store})
+	return &Client{
+		Groups:   &storeGroupsClient{srv},
+		Profiles: &storeProfilesClient{srv},
+		Ignition: &storeIgnitionClient{srv},
+		Generic:  &storeGenericClient{srv},
+	}
+}
+
+// storeError transforms a server error into a gRPC error, like the matchbox
+// gRPC API. Missing files are reported as codes.NotFound.
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, server.ErrNoMatchingGroup), errors.Is(err, server.ErrNoMatchingProfile):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+// ctxErr returns the gRPC error for a done context (e.g. a resource timeout
+// elapsed), like the gRPC API, or nil if the context isn't done.
+func ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// storeGroupsClient implements rpcpb.GroupsClient with a matchbox Server.
+type storeGroupsClient struct {
+	srv server.Server
+}
+
+func (c *storeGroupsClient) GroupPut(ctx context.Context, req *serverpb.GroupPutRequest, opts ...grpc.CallOption) (*serverpb.GroupPutResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	_, err := c.srv.GroupPut(ctx, req)
+	return &serverpb.GroupPutResponse{}, storeError(err)
+}
+
+func (c *storeGroupsClient) GroupGet(ctx context.Context, req *serverpb.GroupGetRequest, opts ...grpc.CallOption) (*serverpb.GroupGetResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	group, err := c.srv.GroupGet(ctx, req)
+	return &serverpb.GroupGetResponse{Group: group}, storeError(err)
+}
+
+func (c *storeGroupsClient) GroupDelete(ctx context.Context, req *serverpb.GroupDeleteRequest, opts ...grpc.CallOption) (*serverpb.GroupDeleteResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	err := c.srv.GroupDelete(ctx, req)
+	return &serverpb.GroupDeleteResponse{}, storeError(err)
+}
+
+func (c *storeGroupsClient) GroupList(ctx context.Context, req *serverpb.GroupListRequest, opts ...grpc.CallOption) (*serverpb.GroupListResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	groups, err := c.srv.GroupList(ctx, req)
+	return &serverpb.GroupListResponse{Groups: groups}, storeError(err)
+}
+
+// storeProfilesClient implements rpcpb.ProfilesClient with a matchbox Server.
+type storeProfilesClient struct {
+	srv server.Server
+}
+
+func (c *storeProfilesClient) ProfilePut(ctx context.Context, req *serverpb.ProfilePutRequest, opts ...grpc.CallOption) (*serverpb.ProfilePutResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	_, err := c.srv.ProfilePut(ctx, req)
+	return &serverpb.ProfilePutResponse{}, storeError(err)
+}
+
+func (c *storeProfilesClient) ProfileGet(ctx context.Context, req *serverpb.ProfileGetRequest, opts ...grpc.CallOption) (*serverpb.ProfileGetResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	profile, err := c.srv.ProfileGet(ctx, req)
+	return &serverpb.ProfileGetResponse{Profile: profile}, storeError(err)
+}
+
+func (c *storeProfilesClient) ProfileDelete(ctx context.Context, req *serverpb.ProfileDeleteRequest, opts ...grpc.CallOption) (*serverpb.ProfileDeleteResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	err := c.srv.ProfileDelete(ctx, req)
+	return &serverpb.ProfileDeleteResponse{}, storeError(err)
+}
+
+func (c *storeProfilesClient) ProfileList(ctx context.Context, req *serverpb.ProfileListRequest, opts ...grpc.CallOption) (*serverpb.ProfileListResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	profiles, err := c.srv.ProfileList(ctx, req)
+	return &serverpb.ProfileListResponse{Profiles: profiles}, storeError(err)
+}
+
+// storeIgnitionClient implements rpcpb.IgnitionClient with a matchbox Server.
+type storeIgnitionClient struct {
+	srv server.Server
+}
+
+func (c *storeIgnitionClient) IgnitionPut(ctx context.Context, req *serverpb.IgnitionPutRequest, opts ...grpc.CallOption) (*serverpb.IgnitionPutResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	_, err := c.srv.IgnitionPut(ctx, req)
+	return &serverpb.IgnitionPutResponse{}, storeError(err)
+}
+
+func (c *storeIgnitionClient) IgnitionGet(ctx context.Context, req *serverpb.IgnitionGetRequest, opts ...grpc.CallOption) (*serverpb.IgnitionGetResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	template, err := c.srv.IgnitionGet(ctx, req)
+	return &serverpb.IgnitionGetResponse{Config: []byte(template)}, storeError(err)
+}
+
+func (c *storeIgnitionClient) IgnitionDelete(ctx context.Context, req *serverpb.IgnitionDeleteRequest, opts ...grpc.CallOption) (*serverpb.IgnitionDeleteResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	err := c.srv.IgnitionDelete(ctx, req)
+	return &serverpb.IgnitionDeleteResponse{}, storeError(err)
+}
+
+// storeGenericClient implements rpcpb.GenericClient with a matchbox Server.
+type storeGenericClient struct {
+	srv server.Server
+}
+
+func (c *storeGenericClient) GenericPut(ctx context.Context, req *serverpb.GenericPutRequest, opts ...grpc.CallOption) (*serverpb.GenericPutResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	_, err := c.srv.GenericPut(ctx, req)
+	return &serverpb.GenericPutResponse{}, storeError(err)
+}
+
+func (c *storeGenericClient) GenericGet(ctx context.Context, req *serverpb.GenericGetRequest, opts ...grpc.CallOption) (*serverpb.GenericGetResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	template, err := c.srv.GenericGet(ctx, req)
+	return &serverpb.GenericGetResponse{Config: []byte(template)}, storeError(err)
+}
+
+func (c *storeGenericClient) GenericDelete(ctx context.Context, req *serverpb.GenericDeleteRequest, opts ...grpc.CallOption) (*serverpb.GenericDeleteResponse, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	err := c.srv.GenericDelete(ctx, req)
+	return &serverpb.GenericDeleteResponse{}, storeError(err)
+}
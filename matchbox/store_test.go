@@ -0,0 +1,105 @@
+package matchbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/poseidon/matchbox/matchbox/storage"
+)
+
+// TestDataPath checks resources are written to a matchbox data directory when
+// the provider is configured with a data_path.
+func TestDataPath(t *testing.T) {
+	dataPath := t.TempDir()
+	store := storage.NewFileStore(&storage.Config{Root: dataPath})
+
+	hcl := fmt.Sprintf(`
+		provider "matchbox" {
+			data_path = "%s"
+		}
+
+		resource "matchbox_profile" "default" {
+			name   = "default"
+			kernel = "foo"
+			raw_ignition = "{\"ignition\":{\"version\":\"3.3.0\"}}"
+			generic_config = "experimental"
+		}
+
+		resource "matchbox_group" "default" {
+			name    = "default"
+			profile = matchbox_profile.default.name
+			selector = {
+				os = "installed"
+			}
+		}
+	`, dataPath)
+
+	check := func(s *terraform.State) error {
+		for _, name := range []string{"profiles/default.json", "groups/default.json", "ignition/default.ign", "generic/default"} {
+			if _, err := os.Stat(filepath.Join(dataPath, name)); err != nil {
+				return err
+			}
+		}
+
+		profile, err := store.ProfileGet("default")
+		if err != nil {
+			return err
+		}
+		if profile.GetIgnitionId() != "default.ign" {
+			return fmt.Errorf("profile, found %q", profile.GetIgnitionId())
+		}
+
+		group, err := store.GroupGet("default")
+		if err != nil {
+			return err
+		}
+		if group.GetProfile() != "default" {
+			return fmt.Errorf("group profile, found %q", group.GetProfile())
+		}
+		return nil
+	}
+
+	checkDestroy := func(s *terraform.State) error {
+		for _, name := range []string{"profiles/default.json", "groups/default.json", "ignition/default.ign", "generic/default"} {
+			if _, err := os.Stat(filepath.Join(dataPath, name)); !os.IsNotExist(err) {
+				return fmt.Errorf("want %s removed, got %v", name, err)
+			}
+		}
+		return nil
+	}
+
+	resource.UnitTest(t, resource.TestCase{
+		ProviderFactories: testProviderFactories,
+		CheckDestroy:      checkDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: hcl,
+				Check:  check,
+			},
+			{
+				PreConfig: func() {
+					store.IgnitionPut("default.ign", []byte("altered"))
+				},
+				Config:             hcl,
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				PreConfig: func() {
+					os.Remove(filepath.Join(dataPath, "groups/default.json"))
+				},
+				Config:             hcl,
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: hcl,
+				Check:  check,
+			},
+		},
+	})
+}
@@ -0,0 +1,184 @@
+package matchbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// requestKey is template data matchbox reserves for the HTTP request.
+const requestKey = "request"
+
+// parseTemplate parses a Container Linux Config or generic config template
+// like matchbox, with Go's text/template and no extra functions.
+func parseTemplate(content string) (*template.Template, error) {
+	return template.New("").Option("missingkey=error").Parse(content)
+}
+
+// renderTemplate renders a Container Linux Config or generic config template
+// with data, like matchbox when serving it to a machine.
+func renderTemplate(content string, data map[string]interface{}) (string, error) {
+	tmpl, err := parseTemplate(content)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validateTemplate validates a Container Linux Config or generic config
+// template parses.
+func validateTemplate(i interface{}, path cty.Path) diag.Diagnostics {
+	if _, err := parseTemplate(i.(string)); err != nil {
+		return diag.Diagnostics{{
+			Severity:      diag.Error,
+			Summary:       "Invalid template",
+			Detail:        err.Error(),
+			AttributePath: path,
+		}}
+	}
+	return nil
+}
+
+// templateKeys returns the top-level data keys a template references (e.g.
+// mac for {{.mac}} or {{$.mac}}), which a Group's metadata or selector must
+// define. Keys referenced within range or with blocks, where dot is no longer
+// the data, are skipped.
+func templateKeys(content string) ([]string, error) {
+	tmpl, err := parseTemplate(content)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	if tmpl.Tree != nil {
+		walkTemplate(tmpl.Tree.Root, true, keys)
+	}
+	delete(keys, requestKey)
+
+	var sorted []string
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	slices.Sort(sorted)
+	return sorted, nil
+}
+
+// walkTemplate records the keys referenced by a template node. Root is true
+// if dot is the template data.
+func walkTemplate(node parse.Node, root bool, keys map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplate(child, root, keys)
+		}
+	case *parse.ActionNode:
+		walkTemplate(n.Pipe, root, keys)
+	case *parse.TemplateNode:
+		walkTemplate(n.Pipe, root, keys)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, root, root, keys)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, root, false, keys)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, root, false, keys)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				walkTemplate(arg, root, keys)
+			}
+		}
+	case *parse.ChainNode:
+		walkTemplate(n.Node, root, keys)
+	case *parse.FieldNode:
+		if root {
+			keys[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		// $ is always the template data
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			keys[n.Ident[1]] = true
+		}
+	}
+}
+
+// walkBranch records the keys referenced by an if, range, or with node, whose
+// body may have a different dot than its pipeline and else branch.
+func walkBranch(n *parse.BranchNode, root, bodyRoot bool, keys map[string]bool) {
+	walkTemplate(n.Pipe, root, keys)
+	walkTemplate(n.List, bodyRoot, keys)
+	walkTemplate(n.ElseList, root, keys)
+}
+
+// profileTemplateKeys returns the keys referenced by a Profile's Container
+// Linux Config and generic config templates. Templates which don't parse are
+// skipped.
+func profileTemplateKeys(containerLinuxConfig, genericConfig string) []string {
+	var keys []string
+	for _, content := range []string{containerLinuxConfig, genericConfig} {
+		referenced, _ := templateKeys(content)
+		for _, key := range referenced {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// planTemplateKeys is a CustomizeDiff function which plans a Profile's
+// template_keys from its Container Linux Config and generic config.
+func planTemplateKeys(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if !d.NewValueKnown("container_linux_config") || !d.NewValueKnown("generic_config") {
+		return d.SetNewComputed("template_keys")
+	}
+	keys := profileTemplateKeys(d.Get("container_linux_config").(string), d.Get("generic_config").(string))
+	return d.SetNew("template_keys", keys)
+}
+
+// validateGroupKeys is a CustomizeDiff function which checks a Group's
+// metadata or selector defines its required_metadata_keys (e.g. a Profile's
+// template_keys), like matchbox when rendering templates.
+func validateGroupKeys(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	for _, key := range []string{"required_metadata_keys", "metadata", "selector"} {
+		if !d.NewValueKnown(key) {
+			return nil
+		}
+	}
+
+	defined := map[string]bool{requestKey: true}
+	for key := range d.Get("metadata").(map[string]interface{}) {
+		defined[key] = true
+	}
+	// matchbox lower cases selector keys in template data
+	for key := range d.Get("selector").(map[string]interface{}) {
+		defined[strings.ToLower(key)] = true
+	}
+
+	var missing []string
+	for _, key := range d.Get("required_metadata_keys").([]interface{}) {
+		if key, ok := key.(string); ok && key != "" && !defined[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("metadata: missing required_metadata_keys %s, which templates would fail to render without", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package matchbox
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTemplateKeys(t *testing.T) {
+	cases := []struct {
+		template string
+		expected []string
+	}{
+		{"hostname: {{.hostname}}\nmac: {{.MAC}}", []string{"MAC", "hostname"}},
+		{"{{if .ssh_key}}{{.ssh_key}}{{else}}{{.fallback}}{{end}}", []string{"fallback", "ssh_key"}},
+		{"{{range .disks}}{{.device}}{{$.pool}}{{end}}", []string{"disks", "pool"}},
+		{"{{with .etcd}}{{.name}}{{else}}{{.etcd_default}}{{end}}", []string{"etcd", "etcd_default"}},
+		{`{{index .labels "rack"}} {{.domain | printf "%s"}} {{(.cluster).name}}`, []string{"cluster", "domain", "labels"}},
+		{"{{.request.query.mac}} {{.request.raw_query}}", nil},
+		{"no templating", nil},
+	}
+	for _, c := range cases {
+		keys, err := templateKeys(c.template)
+		if err != nil {
+			t.Errorf("templateKeys(%q): %v", c.template, err)
+			continue
+		}
+		if !slices.Equal(keys, c.expected) {
+			t.Errorf("templateKeys(%q): expected %q, got %q", c.template, c.expected, keys)
+		}
+	}
+
+	// matchbox templates have no functions beyond text/template's
+	for _, invalid := range []string{"{{.mac_address", `{{toUpper .name}}`} {
+		if _, err := templateKeys(invalid); err == nil {
+			t.Errorf("templateKeys(%q): expected error", invalid)
+		}
+	}
+
+	if keys := profileTemplateKeys("{{.mac}} {{.domain}}", "{{.domain}} {{.k8s_version}}"); strings.Join(keys, ",") != "domain,k8s_version,mac" {
+		t.Errorf("profileTemplateKeys: expected domain, k8s_version, mac, got %q", keys)
+	}
+}